@@ -3,14 +3,15 @@
 package termbox
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"runtime"
 	"syscall"
 	"time"
-
-	"github.com/mattn/go-runewidth"
 )
 
 // public API
@@ -59,6 +60,9 @@ func Init() error {
 	if err != nil {
 		return fmt.Errorf("termbox: error while reading terminfo data: %v", err)
 	}
+	key_trie_mu.Lock()
+	rebuild_key_trie()
+	key_trie_mu.Unlock()
 
 	signal.Notify(sigwinch, syscall.SIGWINCH)
 	signal.Notify(sigio, syscall.SIGIO)
@@ -98,6 +102,7 @@ func Init() error {
 	out.WriteString(funcs[t_clear_screen])
 
 	termw, termh = get_term_size(outfd)
+	update_cell_size()
 	back_buffer.init(termw, termh)
 	front_buffer.init(termw, termh)
 	back_buffer.clear()
@@ -127,6 +132,14 @@ func Init() error {
 		}
 	}()
 
+	if width_policy.Probe {
+		probe_widths()
+		out.WriteString(funcs[t_clear_screen])
+	}
+	if identify_on_init {
+		identify_terminal()
+	}
+
 	IsInit = true
 	return nil
 }
@@ -146,12 +159,34 @@ func Close() {
 	}
 
 	quit <- 1
+	restore_cursor()
+	if title_pushed {
+		out.WriteString("\033[23;0t")
+	}
 	out.WriteString(funcs[t_show_cursor])
 	out.WriteString(funcs[t_sgr0])
 	out.WriteString(funcs[t_clear_screen])
 	out.WriteString(funcs[t_exit_ca])
 	out.WriteString(funcs[t_exit_keypad])
+	if input_mode&InputPixels != 0 {
+		out.WriteString(ti_pixels_leave)
+	}
+	if input_mode&InputHover != 0 {
+		out.WriteString(ti_hover_leave)
+	}
 	out.WriteString(funcs[t_exit_mouse])
+	if input_mode&InputPaste != 0 {
+		out.WriteString(ti_ext_or("BD", ti_paste_leave))
+	}
+	if input_mode&InputFocus != 0 {
+		out.WriteString(ti_focus_leave)
+	}
+	if kitty_pushed {
+		out.WriteString(ti_kitty_pop)
+	}
+	if input_mode&InputModifyOtherKeys != 0 {
+		out.WriteString(ti_other_keys_leave)
+	}
 	tcsetattr(outfd, &orig_tios)
 
 	out.Close()
@@ -167,6 +202,16 @@ func Close() {
 	lastbg = attr_invalid
 	lastx = coord_invalid
 	lasty = coord_invalid
+	lastlink = nil
+	cursor_style_changed = false
+	cursor_color_changed = false
+	title_pushed = false
+	reset_queries()
+	kitty_pushed = false
+	kitty_queried = false
+	kitty_supported = false
+	paste_scan = 0
+	paste_continues = false
 	cursor_x = cursor_hidden
 	cursor_y = cursor_hidden
 	foreground = ColorDefault
@@ -191,16 +236,16 @@ func Flush() error {
 			if back.Ch < ' ' {
 				back.Ch = ' '
 			}
-			w := runewidth.RuneWidth(back.Ch)
-			if w == 0 || w == 2 && runewidth.IsAmbiguousWidth(back.Ch) {
-				w = 1
-			}
-			if *back == *front {
+			w := rune_width(back.Ch)
+			link := back_buffer.links[cell_offset]
+			if *back == *front && link == front_buffer.links[cell_offset] {
 				x += w
 				continue
 			}
 			*front = *back
+			front_buffer.links[cell_offset] = link
 			send_attr(back.Fg, back.Bg)
+			send_link(link)
 
 			if w == 2 && x == front_buffer.width-1 {
 				// there's not enough space for 2-cells rune,
@@ -215,14 +260,19 @@ func Flush() error {
 						Fg: back.Fg,
 						Bg: back.Bg,
 					}
+					front_buffer.links[next] = link
 				}
 			}
 			x += w
 		}
 	}
+	send_link(nil)
 	if !is_cursor_hidden(cursor_x, cursor_y) {
 		write_cursor(cursor_x, cursor_y)
 	}
+	if cell_size_requested() {
+		request_cell_size()
+	}
 	return flush()
 }
 
@@ -247,6 +297,162 @@ func HideCursor() {
 	SetCursor(cursor_hidden, cursor_hidden)
 }
 
+// Sets the shape of the cursor and whether it blinks, CursorDefault brings back
+// the terminal's default. Terminals without the DECSCUSR sequence (terminfo
+// Ss capability) ignore it. The original style is restored on Close. Takes
+// effect on the next Flush.
+func SetCursorStyle(style CursorStyle) {
+	if style < CursorDefault || style > CursorSteadyBar {
+		return
+	}
+	seq, ok := ti_param1(ti_ext["Ss"], int(style))
+	if !ok {
+		return
+	}
+	outbuf.WriteString(seq)
+	cursor_style_changed = style != CursorDefault
+}
+
+// Sets the terminal window title, using the terminfo tsl/fsl capabilities if
+// present, or the OSC 2 sequence otherwise. The previous title is restored on
+// Close. Takes effect on the next Flush.
+func SetTitle(title string) {
+	write_title(2, title)
+}
+
+// Sets both the terminal window title and the icon name using the OSC 0
+// sequence. The previous ones are restored on Close. Takes effect on the next
+// Flush.
+func SetTitleAndIconName(s string) {
+	write_title(0, s)
+}
+
+// Sets the terminal icon name (the title of the minimized window or the tab)
+// using the OSC 1 sequence. The previous icon name is restored on Close. Takes
+// effect on the next Flush.
+func SetIconName(name string) {
+	write_title(1, name)
+}
+
+// Puts 'data' into the system clipboard (or another selection) using the
+// OSC 52 sequence. The sequence is wrapped for passthrough when running
+// inside tmux or screen. Note that many terminals ignore it unless it is
+// explicitly allowed in their settings. Takes effect on the next Flush.
+func SetClipboard(selection Selection, data string) error {
+	if !has_osc {
+		return errors.New("termbox: the terminal doesn't support OSC sequences")
+	}
+	write_passthrough(clipboard_sequence(selection,
+		base64.StdEncoding.EncodeToString([]byte(data))))
+	return nil
+}
+
+// Asks the terminal for the contents of the system clipboard (or another
+// selection) using the OSC 52 sequence. The function doesn't wait for the
+// reply, it arrives later through PollEvent as an EventClipboard event.
+// Terminals which don't support or don't allow reading the clipboard don't
+// reply at all, termbox stops waiting for the reply after the query timeout,
+// see SetQueryTimeout. The query is sent with the next Flush.
+func GetClipboard(selection Selection) error {
+	if !has_osc {
+		return errors.New("termbox: the terminal doesn't support OSC sequences")
+	}
+	write_passthrough(clipboard_sequence(selection, "?"))
+	queue_query(query_clipboard)
+	return nil
+}
+
+// Asks the terminal where its cursor is, using the DSR 6 (CSI 6n) sequence,
+// and waits for the reply, see SetQueryTimeout. The coordinates are 0-based,
+// like the ones of SetCursor. The reply is taken out of the input, it never
+// shows up as a key event. The function may be called while another
+// goroutine waits in PollEvent. Returns ErrNoReply if the terminal doesn't
+// reply in time.
+func GetCursorPosition() (x, y int, err error) {
+	reply, ok := query("\033[6n", query_cursor)
+	if !ok {
+		return 0, 0, ErrNoReply
+	}
+	row, col, _ := parse_cursor_report([]byte(reply))
+	return col - 1, row - 1, nil
+}
+
+// Asks the terminal to identify itself with the XTVERSION, secondary (DA2)
+// and primary (DA1) device attributes queries, and waits for the replies, see
+// SetQueryTimeout. All terminals reply to DA1, the other two are optional, so
+// some fields of the result may be left empty. The replies are taken out of
+// the input, they never show up as key events. The function may be called
+// while another goroutine waits in PollEvent. Returns ErrNoReply if the
+// terminal doesn't reply in time. See also SetIdentifyOnInit.
+func IdentifyTerminal() (TerminalInfo, error) {
+	return identify_terminal()
+}
+
+// Asks the terminal for its default foreground and background colors, using
+// the OSC 10 and OSC 11 sequences, and for the first 16 colors of its palette,
+// using OSC 4, and waits for the replies, see SetQueryTimeout. Colors the
+// terminal doesn't report are left as ColorDefault. The replies are taken out
+// of the input, they never show up as key events. The function may be called
+// while another goroutine waits in PollEvent. Returns ErrNoReply if the
+// terminal doesn't reply in time.
+func QueryColors() (TerminalColors, error) {
+	if !has_osc {
+		return TerminalColors{}, errors.New("termbox: the terminal doesn't support OSC sequences")
+	}
+	return query_colors()
+}
+
+// Returns what the last IdentifyTerminal call (or the identification done by
+// Init) found out about the terminal.
+func Terminal() TerminalInfo {
+	query_mu.Lock()
+	defer query_mu.Unlock()
+	return terminal_info
+}
+
+// Sets whether Init identifies the terminal, see IdentifyTerminal. Init then
+// waits for the replies, which takes a round trip to the terminal. Returns the
+// previous setting.
+func SetIdentifyOnInit(identify bool) bool {
+	prev := identify_on_init
+	identify_on_init = identify
+	return prev
+}
+
+// Sets how long the functions which query the terminal, like
+// GetCursorPosition, wait for the reply. The default is one second. Returns
+// the previous timeout.
+func SetQueryTimeout(d time.Duration) time.Duration {
+	if d < 0 {
+		d = 0
+	}
+	prev := query_timeout
+	query_timeout = d
+	return prev
+}
+
+// Sets the color of the cursor using the OSC 12 sequence. Colors created by
+// RGBToAttribute are used as is, palette colors are interpreted according to
+// the current output mode and converted using the default xterm palette.
+// ColorDefault brings back the terminal's default cursor color, which is also
+// restored on Close. Takes effect on the next Flush. Does nothing on terminals
+// without OSC support, like the Linux console.
+func SetCursorColor(color Attribute) {
+	if !has_osc {
+		return
+	}
+	if color&0x1FF == ColorDefault && color < max_attr {
+		outbuf.WriteString("\033]112\033\\")
+		cursor_color_changed = false
+		return
+	}
+	r, g, b := attribute_to_rgb(color)
+	outbuf.WriteString("\033]12;")
+	outbuf.WriteString(rgb_spec(r, g, b))
+	outbuf.WriteString("\033\\")
+	cursor_color_changed = true
+}
+
 // Changes cell's parameters in the internal back buffer at the specified
 // position.
 func SetCell(x, y int, ch rune, fg, bg Attribute) {
@@ -257,7 +463,8 @@ func SetCell(x, y int, ch rune, fg, bg Attribute) {
 		return
 	}
 
-	back_buffer.cells[y*back_buffer.width+x] = Cell{ch, fg, bg}
+	back_buffer.cells[y*back_buffer.width+x] = Cell{Ch: ch, Fg: fg, Bg: bg}
+	back_buffer.links[y*back_buffer.width+x] = nil
 }
 
 // Returns the specified cell from the internal back buffer.
@@ -304,6 +511,21 @@ func SetBg(x, y int, bg Attribute) {
 	back_buffer.cells[y*back_buffer.width+x].Bg = bg
 }
 
+// Attaches a hyperlink to the cell in the internal back buffer at the
+// specified position, nil removes it. Consecutive cells with the same link
+// are sent to the terminal as a single link. Note that SetCell resets the
+// link, so call this function after it.
+func SetLink(x, y int, link *Hyperlink) {
+	if x < 0 || x >= back_buffer.width {
+		return
+	}
+	if y < 0 || y >= back_buffer.height {
+		return
+	}
+
+	back_buffer.links[y*back_buffer.width+x] = link
+}
+
 // Returns a slice into the termbox's back buffer. You can get its dimensions
 // using 'Size' function. The slice remains valid as long as no 'Clear' or
 // 'Flush' function calls were made after call to this function.
@@ -343,6 +565,10 @@ func PollRawEvent(data []byte) Event {
 		panic("len(data) >= 1 is a requirement")
 	}
 
+	// a query may be reading the input, see GetCursorPosition
+	poll_token <- struct{}{}
+	defer func() { <-poll_token }()
+
 	var event Event
 	if extract_raw_event(data, &event) {
 		return event
@@ -355,6 +581,9 @@ func PollRawEvent(data []byte) Event {
 				return Event{Type: EventError, Err: ev.err}
 			}
 
+			if recording != nil {
+				recording.input(ev.data)
+			}
 			inbuf = append(inbuf, ev.data...)
 			input_comm <- ev
 			if extract_raw_event(data, &event) {
@@ -367,6 +596,10 @@ func PollRawEvent(data []byte) Event {
 		case <-sigwinch:
 			event.Type = EventResize
 			event.Width, event.Height = get_term_size(outfd)
+			update_cell_size()
+			if recording != nil {
+				recording.resize(event.Width, event.Height)
+			}
 			return event
 		}
 	}
@@ -374,32 +607,40 @@ func PollRawEvent(data []byte) Event {
 
 // Wait for an event and return it. This is a blocking function call.
 func PollEvent() Event {
-	// Constant governing macOS specific behavior. See https://github.com/nsf/termbox-go/issues/132
-	// This is an arbitrary delay which hopefully will be enough time for any lagging
-	// partial escape sequences to come through.
-	const esc_wait_delay = 100 * time.Millisecond
-
 	var event Event
 	var esc_wait_timer *time.Timer
 	var esc_timeout <-chan time.Time
 
-	// try to extract event from input buffer, return on success
-	event.Type = EventKey
-	status := extract_event(inbuf, &event, true)
-	if event.N != 0 {
-		copy(inbuf, inbuf[event.N:])
-		inbuf = inbuf[:len(inbuf)-event.N]
+	// a query may be reading the input, see GetCursorPosition, the events it
+	// read along the way come first
+	poll_token <- struct{}{}
+	defer func() { <-poll_token }()
+	if len(event_queue) != 0 {
+		event = event_queue[0]
+		event_queue = event_queue[1:]
+		return event
+	}
+
+	// while replaying, the terminal input waits until the replay ends, and
+	// when replaying fast, the recorded times decide the Esc key delays
+	input := input_comm
+	if replay_comm != nil {
+		input = nil
 	}
+	esc_timer := replay_comm == nil || replay_realtime
+
+	// try to extract event from input buffer, return on success
+	status := extract_next_event(&event, true)
 	if status == event_extracted {
 		return event
-	} else if status == esc_wait {
-		esc_wait_timer = time.NewTimer(esc_wait_delay)
+	} else if status == esc_wait && esc_timer {
+		esc_wait_timer = time.NewTimer(esc_wait_delay(inbuf))
 		esc_timeout = esc_wait_timer.C
 	}
 
 	for {
 		select {
-		case ev := <-input_comm:
+		case ev := <-input:
 			if esc_wait_timer != nil {
 				if !esc_wait_timer.Stop() {
 					<-esc_wait_timer.C
@@ -411,27 +652,61 @@ func PollEvent() Event {
 				return Event{Type: EventError, Err: ev.err}
 			}
 
+			if recording != nil {
+				recording.input(ev.data)
+			}
 			inbuf = append(inbuf, ev.data...)
 			input_comm <- ev
-			status := extract_event(inbuf, &event, true)
-			if event.N != 0 {
-				copy(inbuf, inbuf[event.N:])
-				inbuf = inbuf[:len(inbuf)-event.N]
-			}
+			status := extract_next_event(&event, true)
 			if status == event_extracted {
 				return event
 			} else if status == esc_wait {
-				esc_wait_timer = time.NewTimer(esc_wait_delay)
+				esc_wait_timer = time.NewTimer(esc_wait_delay(inbuf))
+				esc_timeout = esc_wait_timer.C
+			}
+		case r, ok := <-replay_comm:
+			if esc_wait_timer != nil {
+				if !esc_wait_timer.Stop() {
+					<-esc_wait_timer.C
+				}
+				esc_wait_timer = nil
+			}
+
+			if !ok {
+				// the replay is over, report what's left of the input
+				// first
+				if extract_next_event(&event, false) == event_extracted {
+					return event
+				}
+				replay_comm, replay_stop = nil, nil
+				return Event{Type: EventError, Err: ErrReplayDone}
+			}
+			if r.data == nil {
+				event.Type = EventResize
+				event.Width, event.Height = r.w, r.h
+				return event
+			}
+
+			status := event_not_extracted
+			if !esc_timer && r.gap > esc_delay {
+				// the Esc key delay has passed before this input
+				status = extract_next_event(&event, false)
+			}
+			inbuf = append(inbuf, r.data...)
+			if status == event_extracted {
+				return event
+			}
+			status = extract_next_event(&event, true)
+			if status == event_extracted {
+				return event
+			} else if status == esc_wait && esc_timer {
+				esc_wait_timer = time.NewTimer(esc_wait_delay(inbuf))
 				esc_timeout = esc_wait_timer.C
 			}
 		case <-esc_timeout:
 			esc_wait_timer = nil
 
-			status := extract_event(inbuf, &event, false)
-			if event.N != 0 {
-				copy(inbuf, inbuf[event.N:])
-				inbuf = inbuf[:len(inbuf)-event.N]
-			}
+			status := extract_next_event(&event, false)
 			if status == event_extracted {
 				return event
 			}
@@ -442,11 +717,25 @@ func PollEvent() Event {
 		case <-sigwinch:
 			event.Type = EventResize
 			event.Width, event.Height = get_term_size(outfd)
+			update_cell_size()
+			if recording != nil {
+				recording.resize(event.Width, event.Height)
+			}
 			return event
 		}
 	}
 }
 
+// Returns the size of a cell in pixels, or zeros if it isn't known. Terminals
+// which don't report it along with the window size are asked for it when
+// Pixels input mode is enabled, with the next Flush, so it may become known a
+// bit later.
+func CellSize() (width int, height int) {
+	query_mu.Lock()
+	defer query_mu.Unlock()
+	return cell_w, cell_h
+}
+
 // Returns the size of the internal back buffer (which is mostly the same as
 // terminal's window size in characters). But it doesn't always match the size
 // of the terminal window, after the terminal size has changed, the internal
@@ -472,7 +761,45 @@ func Clear(fg, bg Attribute) error {
 // any known sequence. ESC enables ModAlt modifier for the next keyboard event.
 //
 // Both input modes can be OR'ed with Mouse mode. Setting Mouse mode bit up will
-// enable mouse button press/release and drag events.
+// enable mouse button press/release and drag events. Mouse events carry the
+// Shift, Alt and Ctrl modifiers held at the time, and with the SGR encoding
+// most terminals use, releases tell which button was released (see
+// Event.Button).
+//
+// Mouse mode can in turn be OR'ed with Hover mode, which makes the terminal
+// report the mouse motion even when no button is pressed, as MouseHover
+// events with ModMotion. Bursts of motion events are coalesced, only the
+// last position is reported if several are waiting in the input buffer.
+//
+// Mouse mode can also be OR'ed with Pixels mode, which enables the SGR-Pixels
+// mouse reporting. Mouse events then carry the position in pixels in the
+// 'PixelX' and 'PixelY' fields, and the cell position computed from them
+// using the cell size (see CellSize).
+//
+// They can also be OR'ed with Paste mode, which enables bracketed paste. Text
+// pasted into the terminal then arrives as a single EventPaste event instead
+// of a series of EventKey events. Its 'Text' field contains the text exactly
+// as the terminal sends it, which usually means "\r" line endings. Pastes
+// longer than a megabyte arrive as several EventPaste events in a row.
+//
+// And with Focus mode, which makes termbox report EventFocus events when the
+// terminal window gains or loses the focus.
+//
+// And with Kitty mode, which enables the kitty keyboard protocol on terminals
+// supporting it. Every key is then reported unambiguously with all of its
+// modifiers (so Tab and Ctrl+I, or Enter and Ctrl+M are different), key
+// repeats are reported too (see Event.Action) along with the shifted and the
+// base layout keys and the text the key produces. The first time, termbox
+// asks the terminal whether it supports the protocol and waits for the reply
+// (see SetQueryTimeout). Other terminals simply keep sending the legacy
+// sequences. Together with KeyRelease mode, key releases are reported as well,
+// as EventKey events with KeyRelease in the Action field.
+//
+// And with ModifyOtherKeys mode, which enables xterm's modifyOtherKeys (level
+// 2) for terminals without the kitty protocol. Keys pressed with modifiers
+// are then reported along with the modifiers, so that shortcuts like
+// Ctrl+Shift+A (KeyCtrlA with ModCtrl and ModShift) or Ctrl+1 ('1' with
+// ModCtrl) work. Key releases are not reported in this mode.
 //
 // If 'mode' is InputCurrent, returns the current input mode. See also Input*
 // constants.
@@ -486,16 +813,183 @@ func SetInputMode(mode InputMode) InputMode {
 	if mode&(InputEsc|InputAlt) == InputEsc|InputAlt {
 		mode &^= InputAlt
 	}
+	if input_mode&InputPixels != 0 && mode&InputPixels == 0 {
+		// before the mouse sequences, which set the SGR encoding back
+		out.WriteString(ti_pixels_leave)
+	}
 	if mode&InputMouse != 0 {
 		out.WriteString(funcs[t_enter_mouse])
 	} else {
 		out.WriteString(funcs[t_exit_mouse])
 	}
+	if mode&(InputMouse|InputHover) == InputMouse|InputHover && funcs[t_enter_mouse] != "" {
+		out.WriteString(ti_hover_enter)
+	} else if input_mode&InputHover != 0 {
+		out.WriteString(ti_hover_leave)
+	}
+	if mode&(InputMouse|InputPixels) == InputMouse|InputPixels && funcs[t_enter_mouse] != "" {
+		out.WriteString(ti_pixels_enter)
+		if w, h := CellSize(); w == 0 || h == 0 {
+			request_cell_size()
+		}
+	}
+	if mode&InputPaste != 0 {
+		out.WriteString(ti_ext_or("BE", ti_paste_enter))
+	} else if input_mode&InputPaste != 0 {
+		out.WriteString(ti_ext_or("BD", ti_paste_leave))
+	}
+	if mode&InputFocus != 0 {
+		out.WriteString(ti_focus_enter)
+	} else if input_mode&InputFocus != 0 {
+		out.WriteString(ti_focus_leave)
+	}
+	if mode&InputKitty != 0 && kitty_pushed && (mode^input_mode)&InputKeyRelease != 0 {
+		out.WriteString(ti_kitty_pop)
+		kitty_pushed = false
+	}
+	if mode&InputKitty != 0 && !kitty_pushed {
+		if !kitty_queried {
+			kitty_queried = true
+			detect_kitty()
+		}
+		query_mu.Lock()
+		supported := kitty_supported
+		query_mu.Unlock()
+		if supported {
+			if mode&InputKeyRelease != 0 {
+				out.WriteString(ti_kitty_push_release)
+			} else {
+				out.WriteString(ti_kitty_push)
+			}
+			kitty_pushed = true
+		}
+	} else if mode&InputKitty == 0 && kitty_pushed {
+		out.WriteString(ti_kitty_pop)
+		kitty_pushed = false
+	}
+	if mode&InputModifyOtherKeys != 0 {
+		out.WriteString(ti_other_keys_enter)
+	} else if input_mode&InputModifyOtherKeys != 0 {
+		out.WriteString(ti_other_keys_leave)
+	}
 
 	input_mode = mode
 	return input_mode
 }
 
+// Sets how long PollEvent waits for the rest of an escape sequence which was
+// only partially read, before it decides that the Esc key was pressed (or Alt
+// in Alt input mode) and reports the bytes read so far as keys. Termbox only
+// waits when the input is the beginning of a sequence it knows of, so the
+// delay mostly applies to the Esc key itself. The default is zero, which
+// disables the waiting, except on macOS, where it is 100ms. Something like
+// 50-100ms helps over slow connections, where sequences may arrive in pieces.
+// Returns the previous delay.
+func SetEscDelay(d time.Duration) time.Duration {
+	if d < 0 {
+		d = 0
+	}
+	prev := esc_delay
+	esc_delay = d
+	return prev
+}
+
+// Registers an extra input sequence for a key, in addition to the ones known
+// from terminfo. When the terminal sends 'seq', PollEvent and ParseEvent
+// report an EventKey event with the given 'key' and 'mod'. The key may be one
+// of the Key* constants or a custom key code, see KeyUser. Registered
+// sequences take precedence over the built-in ones, registering the same
+// sequence again replaces it. Sequences which don't start with Esc are only
+// recognized when they are read at once. It is safe to call while another
+// goroutine is in PollEvent.
+func RegisterKey(seq string, key Key, mod Modifier) {
+	if seq == "" {
+		return
+	}
+	key_trie_mu.Lock()
+	defer key_trie_mu.Unlock()
+	for i := range user_keys {
+		if user_keys[i].seq == seq {
+			user_keys[i] = mod_key{seq, key, mod}
+			rebuild_key_trie()
+			return
+		}
+	}
+	user_keys = append(user_keys, mod_key{seq, key, mod})
+	rebuild_key_trie()
+}
+
+// Removes a sequence registered with RegisterKey.
+func UnregisterKey(seq string) {
+	key_trie_mu.Lock()
+	defer key_trie_mu.Unlock()
+	for i := range user_keys {
+		if user_keys[i].seq == seq {
+			user_keys = append(user_keys[:i], user_keys[i+1:]...)
+			rebuild_key_trie()
+			return
+		}
+	}
+}
+
+// Starts recording the input read by PollEvent and PollRawEvent to 'w': the
+// raw input bytes and the terminal resizes, with the time they arrived at.
+// The recording is a text file, which Replay plays back. Stops the previous
+// recording, if any.
+func StartRecording(w io.Writer) error {
+	StopRecording()
+	if _, err := io.WriteString(w, recording_header+"\n"); err != nil {
+		return err
+	}
+	recording = &recorder{w: w, start: time.Now()}
+	return nil
+}
+
+// Stops the recording started with StartRecording. Returns the first error
+// writing it, if any.
+func StopRecording() error {
+	if recording == nil {
+		return nil
+	}
+	err := recording.err
+	recording = nil
+	return err
+}
+
+// Plays back a recording made with StartRecording. The recorded input goes
+// through the same parser as the terminal input, and PollEvent returns the
+// events from it instead of the terminal input, until the recording ends.
+// Then PollEvent returns an EventError event with ErrReplayDone once and goes
+// back to the terminal input. If 'realtime' is true, the input is played at
+// the recorded pace, otherwise as fast as possible, with the Esc key delays
+// (see SetEscDelay) decided by the recorded times. Replay also works without
+// Init, which is useful in tests, but then only the sequences termbox knows
+// without terminfo are recognized. Calling Replay again or with a nil reader
+// stops the previous replay.
+func Replay(r io.Reader, realtime bool) error {
+	if replay_stop != nil {
+		close(replay_stop)
+		replay_comm, replay_stop = nil, nil
+	}
+	if r == nil {
+		return nil
+	}
+	records, err := parse_recording(r)
+	if err != nil {
+		return err
+	}
+	if !IsInit {
+		key_trie_mu.Lock()
+		rebuild_key_trie()
+		key_trie_mu.Unlock()
+	}
+	replay_comm = make(chan record)
+	replay_stop = make(chan struct{})
+	replay_realtime = realtime
+	go replay(records, realtime, replay_comm, replay_stop)
+	return nil
+}
+
 // Sets the termbox output mode. Termbox has four output options:
 //
 // 1. OutputNormal => [1..8]
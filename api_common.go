@@ -1,42 +1,83 @@
 // termbox is a library for creating cross-platform text-based interfaces
 package termbox
 
+import "errors"
+
 // public API, common OS agnostic part
 
 type (
-	InputMode  int
-	OutputMode int
-	EventType  uint8
-	Modifier   uint8
-	Key        uint16
-	Attribute  uint64
+	InputMode   int
+	OutputMode  int
+	EventType   uint8
+	Modifier    uint8
+	Key         uint16
+	Attribute   uint64
+	CursorStyle int
+	Selection   byte
+	KeyAction   uint8
 )
 
 // This type represents a termbox event. The 'Mod', 'Key' and 'Ch' fields are
 // valid if 'Type' is EventKey. The 'Width' and 'Height' fields are valid if
 // 'Type' is EventResize. The 'Err' field is valid if 'Type' is EventError.
+// The 'Selection' and 'Text' fields are valid if 'Type' is EventClipboard,
+// the 'Text' field is valid if 'Type' is EventPaste. The 'Focused' field is
+// valid if 'Type' is EventFocus. The 'Action', 'ShiftedCh', 'BaseCh' and
+// 'Text' fields of EventKey are only filled in Kitty input mode. The 'Button'
+// field of EventMouse is the released button if 'Key' is MouseRelease, it is
+// 0 if the terminal doesn't tell which one it was. The 'PixelX' and 'PixelY'
+// fields of EventMouse are only filled in Pixels input mode. EventClick and
+// EventDrag* events are made by Gestures, they have the same fields as
+// EventMouse, and also 'Clicks' or 'OriginX' and 'OriginY' respectively. The
+// 'Text' field of EventUnknown holds the raw bytes of an escape sequence
+// termbox doesn't recognize.
 type Event struct {
-	Type   EventType // one of Event* constants
-	Mod    Modifier  // one of Mod* constants or 0
-	Key    Key       // one of Key* constants, invalid if 'Ch' is not 0
-	Ch     rune      // a unicode character
-	Width  int       // width of the screen
-	Height int       // height of the screen
-	Err    error     // error in case if input failed
-	MouseX int       // x coord of mouse
-	MouseY int       // y coord of mouse
-	N      int       // number of bytes written when getting a raw event
+	Type      EventType // one of Event* constants
+	Mod       Modifier  // one of Mod* constants or 0
+	Key       Key       // one of Key* constants, invalid if 'Ch' is not 0
+	Ch        rune      // a unicode character
+	Width     int       // width of the screen
+	Height    int       // height of the screen
+	Err       error     // error in case if input failed
+	MouseX    int       // x coord of mouse
+	MouseY    int       // y coord of mouse
+	N         int       // number of bytes written when getting a raw event
+	Selection Selection // one of Selection* constants
+	Text      string    // text carried by the event
+	Focused   bool      // whether the terminal window has gained the focus
+	Action    KeyAction // one of Key{Press,Repeat,Release} constants
+	ShiftedCh rune      // the key with Shift applied, if reported
+	BaseCh    rune      // the key in the standard (US) layout, if reported
+	Button    Key       // the released mouse button, if reported
+	PixelX    int       // x coord of mouse in pixels
+	PixelY    int       // y coord of mouse in pixels
+	Clicks    int       // 1, 2 or 3 for single, double or triple click
+	OriginX   int       // x coord where the drag started
+	OriginY   int       // y coord where the drag started
 }
 
 // A cell, single conceptual entity on the screen. The screen is basically a 2d
 // array of cells. 'Ch' is a unicode character, 'Fg' and 'Bg' are foreground
-// and background attributes respectively.
+// and background attributes respectively. Hyperlinks are kept separately, see
+// SetLink function.
 type Cell struct {
 	Ch rune
 	Fg Attribute
 	Bg Attribute
 }
 
+// A hyperlink target which can be attached to cells, terminals supporting OSC 8
+// make such cells clickable. 'URI' is the target, 'ID' is optional and tells
+// the terminal that separate runs of cells belong to the same link (e.g. a
+// link wrapped over multiple lines). Cells are compared by the pointer, so
+// create a link once and reuse it for all of its cells. Bytes of 'URI' outside
+// of printable ASCII are percent-encoded, an 'ID' with such bytes or with ';'
+// or ':' in it is left out.
+type Hyperlink struct {
+	URI string
+	ID  string
+}
+
 // To know if termbox has been initialized or not
 var (
 	IsInit bool = false
@@ -73,8 +114,107 @@ const (
 	MouseRelease
 	MouseWheelUp
 	MouseWheelDown
+	MouseWheelLeft
+	MouseWheelRight
+	MouseButton4 // extra buttons, usually back and forward come first
+	MouseButton5
+	MouseButton6
+	MouseButton7
+	MouseButton8
+	MouseHover // motion with no button pressed, see InputHover
+
+	// added after the mouse keys, which keep their values, see terminfo
+	KeyF13
+	KeyF14
+	KeyF15
+	KeyF16
+	KeyF17
+	KeyF18
+	KeyF19
+	KeyF20
+	KeyF21
+	KeyF22
+	KeyF23
+	KeyF24
+	KeyBacktab
+	KeyKeypadEnter
+	KeyKeypadA1 // upper left of the keypad
+	KeyKeypadA3 // upper right of the keypad
+	KeyKeypadB2 // center of the keypad
+	KeyKeypadC1 // lower left of the keypad
+	KeyKeypadC3 // lower right of the keypad
+	KeyBegin
+	KeyMenu
+	KeyPrint
+	KeyPause
+	KeyFind
+	KeySelect
 )
 
+// Custom key codes, for keys registered with RegisterKey, start at KeyUser.
+// Termbox itself doesn't use the codes from KeyUser to KeyUser+0x3FFF.
+const KeyUser Key = 0x8000
+
+// The error of the EventError event PollEvent returns when a recording played
+// with Replay ends.
+var ErrReplayDone = errors.New("termbox: end of replay")
+
+// What the terminal says about itself, see IdentifyTerminal.
+type TerminalInfo struct {
+	// The name and version of the terminal emulator from the XTVERSION
+	// reply, e.g. "XTerm" and "380", or "kitty" and "0.31.0". Empty if the
+	// terminal doesn't support the query.
+	Name    string
+	Version string
+
+	// The conformance level from the DA1 reply: 1 for VT100, 2 for VT220 and
+	// so on up to 5 for VT500. Most emulators report 1 or 4.
+	Level int
+
+	// The rest of the DA1 reply, the extensions the terminal supports, e.g.
+	// 4 for sixel graphics or 22 for ANSI colors.
+	Attributes []int
+
+	// The terminal type and firmware version from the DA2 reply. The meaning
+	// varies, e.g. xterm reports its patch number as the firmware version,
+	// VTE its version as a number like 6800.
+	Type     int
+	Firmware int
+}
+
+// The terminal's actual colors, see QueryColors. The colors are made with
+// RGBToAttribute, the ones the terminal didn't report are ColorDefault.
+type TerminalColors struct {
+	// The default foreground and background, what ColorDefault looks like.
+	Foreground Attribute
+	Background Attribute
+
+	// The first 16 colors of the palette, ColorBlack to ColorWhite and their
+	// bright versions.
+	Palette [16]Attribute
+}
+
+// Reports whether the background color is dark, e.g. to pick a light or a
+// dark theme. An unknown background counts as dark, that's what most
+// terminals default to.
+func (c TerminalColors) DarkBackground() bool {
+	if c.Background < max_attr {
+		return true
+	}
+	return IsDark(c.Background)
+}
+
+// Reports whether a color made with RGBToAttribute is dark, that is whether
+// its luma is below the middle.
+func IsDark(color Attribute) bool {
+	r, g, b := AttributeToRGB(color)
+	return 299*int(r)+587*int(g)+114*int(b) < 128*1000
+}
+
+// The error of the functions which query the terminal, like
+// GetCursorPosition, when the terminal doesn't reply in time.
+var ErrNoReply = errors.New("termbox: no reply from the terminal")
+
 const (
 	KeyCtrlTilde      Key = 0x00
 	KeyCtrl2          Key = 0x00
@@ -124,10 +264,29 @@ const (
 	KeyCtrl8          Key = 0x7F
 )
 
-// Alt modifier constant, see Event.Mod field and SetInputMode function.
+// Modifier constants, see Event.Mod field and SetInputMode function. ModCtrl,
+// ModShift and ModMeta are only reported for keys which have no other way to
+// express them, like arrows, navigation and function keys (e.g. Ctrl+Up),
+// Ctrl+A is still just KeyCtrlA. In Kitty and ModifyOtherKeys input modes
+// they are reported for all keys, so that e.g. KeyTab and KeyCtrlI (with
+// ModCtrl) can be told apart. ModSuper and ModHyper are only reported in
+// Kitty input mode.
 const (
 	ModAlt Modifier = 1 << iota
 	ModMotion
+	ModCtrl
+	ModShift
+	ModMeta
+	ModSuper
+	ModHyper
+)
+
+// Key actions, see Event.Action field. Key repeats are only reported in Kitty
+// input mode, and key releases only when it is combined with KeyRelease mode.
+const (
+	KeyPress KeyAction = iota
+	KeyRepeat
+	KeyRelease
 )
 
 // Cell colors, you can combine a color with multiple attributes using bitwise
@@ -176,6 +335,13 @@ const (
 	InputEsc InputMode = 1 << iota
 	InputAlt
 	InputMouse
+	InputPaste
+	InputFocus
+	InputKitty
+	InputModifyOtherKeys
+	InputHover
+	InputPixels
+	InputKeyRelease
 	InputCurrent InputMode = 0
 )
 
@@ -189,6 +355,24 @@ const (
 	OutputRGB
 )
 
+// Cursor style. See SetCursorStyle function.
+const (
+	CursorDefault CursorStyle = iota
+	CursorBlinkingBlock
+	CursorSteadyBlock
+	CursorBlinkingUnderline
+	CursorSteadyUnderline
+	CursorBlinkingBar
+	CursorSteadyBar
+)
+
+// Clipboard selections. See SetClipboard and GetClipboard functions.
+const (
+	SelectionClipboard Selection = 'c'
+	SelectionPrimary   Selection = 'p'
+	SelectionSecondary Selection = 's'
+)
+
 // Event type. See Event.Type field.
 const (
 	EventKey EventType = iota
@@ -198,6 +382,14 @@ const (
 	EventInterrupt
 	EventRaw
 	EventNone
+	EventClipboard
+	EventPaste
+	EventFocus
+	EventClick
+	EventDragStart
+	EventDrag
+	EventDragEnd
+	EventUnknown
 )
 
 // AttributeToRGB converts an Attribute to the underlying rgb triplet.
@@ -226,3 +418,60 @@ func RGBToAttribute(r uint8, g uint8, b uint8) Attribute {
 	// Left-shift back to the place where rgb is stored.
 	return Attribute(color)
 }
+
+// WidthPolicy controls how many cells termbox assumes a rune occupies on the
+// screen. Terminals and fonts disagree about the width of East Asian
+// ambiguous characters and emoji, when termbox's idea of the width doesn't
+// match the terminal's, the screen gets misaligned. See SetWidthPolicy
+// function.
+type WidthPolicy struct {
+	// Width of East Asian ambiguous characters, 1 or 2. Zero means 1.
+	Ambiguous int
+
+	// Width of characters with the default emoji presentation, 1 or 2. Zero
+	// means whatever the Unicode tables say.
+	Emoji int
+
+	// Per-rune widths which take precedence over everything else.
+	Override map[rune]int
+
+	// If not nil, called for every rune before the rules above are applied.
+	// Returning a width less than 1 means "no opinion".
+	OverrideFunc func(r rune) int
+
+	// If true, Init asks the terminal how wide it actually draws an ambiguous
+	// character and an emoji (by printing them concealed on the alternate
+	// screen and reading back the cursor position) and sets Ambiguous and
+	// Emoji to match. Each of them waits for the reply up to the query
+	// timeout, see SetQueryTimeout. Terminals which don't answer leave the
+	// policy unchanged. Emoji ZWJ sequences are not probed: termbox puts
+	// every rune into a cell of its own, so they are never drawn joined. Has
+	// no effect on windows.
+	Probe bool
+}
+
+// SetWidthPolicy changes the way termbox computes rune widths. It affects
+// Flush and the RuneWidth and StringWidth functions. It is safe to call it
+// before Init. Returns the previous policy.
+func SetWidthPolicy(policy WidthPolicy) WidthPolicy {
+	old := width_policy
+	width_policy = policy
+	return old
+}
+
+// RuneWidth returns the number of cells the rune occupies when drawn by
+// Flush, according to the current width policy. Zero-width runes still take
+// a cell of their own, so the result is always 1 or 2.
+func RuneWidth(r rune) int {
+	return rune_width(r)
+}
+
+// StringWidth returns the number of cells the string occupies if its runes
+// are put into consecutive cells, according to the current width policy.
+func StringWidth(s string) int {
+	w := 0
+	for _, r := range s {
+		w += rune_width(r)
+	}
+	return w
+}
@@ -1,9 +1,10 @@
 package termbox
 
 import (
+	"errors"
+	"io"
 	"syscall"
-
-	"github.com/mattn/go-runewidth"
+	"time"
 )
 
 // public API
@@ -96,6 +97,11 @@ func Close() {
 	set_console_screen_buffer_size(out, orig_size)
 	set_console_window_info(out, &orig_window)
 	set_console_cursor_info(out, &orig_cursor_info)
+	cursor_size = 100
+	if orig_title != nil {
+		set_console_title(orig_title)
+		orig_title = nil
+	}
 	set_console_cursor_position(out, coord{})
 	set_console_mode(in, orig_mode)
 	syscall.Close(in)
@@ -124,7 +130,7 @@ func Flush() error {
 	for _, diff := range diffbuf {
 		chars := []char_info{}
 		for _, char := range diff.chars {
-			if runewidth.RuneWidth(rune(char.char)) > 1 {
+			if rune_width(rune(char.char)) > 1 {
 				char.attr |= common_lvb_leading_byte
 				chars = append(chars, char)
 				chars = append(chars, char_info{
@@ -170,6 +176,113 @@ func HideCursor() {
 	SetCursor(cursor_hidden, cursor_hidden)
 }
 
+// Sets the shape of the cursor. Windows console can only change the cursor
+// size, so underline and bar cursors both look like an underline and the
+// blinking can't be turned off. The original cursor is restored on Close.
+func SetCursorStyle(style CursorStyle) {
+	switch style {
+	case CursorDefault:
+		cursor_size = orig_cursor_info.size
+	case CursorBlinkingBlock, CursorSteadyBlock:
+		cursor_size = 100
+	case CursorBlinkingUnderline, CursorSteadyUnderline,
+		CursorBlinkingBar, CursorSteadyBar:
+		cursor_size = 25
+	default:
+		return
+	}
+	if !is_cursor_hidden(cursor_x, cursor_y) {
+		show_cursor(true)
+	}
+}
+
+// Sets the console window title. The previous title is restored on Close.
+func SetTitle(title string) {
+	if orig_title == nil {
+		orig_title, _ = get_console_title()
+	}
+	t, err := syscall.UTF16FromString(title)
+	if err != nil {
+		return
+	}
+	set_console_title(t)
+}
+
+// Sets the console window title, the console doesn't have an icon name. The
+// previous title is restored on Close.
+func SetTitleAndIconName(s string) {
+	SetTitle(s)
+}
+
+// Sets the terminal icon name. Windows console doesn't have one, so at the
+// moment on Windows it does nothing.
+func SetIconName(name string) {
+}
+
+// Puts 'data' into the system clipboard. Not supported on Windows at the
+// moment.
+func SetClipboard(selection Selection, data string) error {
+	return errors.New("termbox: clipboard is not supported on windows")
+}
+
+// Asks for the contents of the system clipboard. Not supported on Windows at
+// the moment.
+func GetClipboard(selection Selection) error {
+	return errors.New("termbox: clipboard is not supported on windows")
+}
+
+// Returns the position of the cursor. On Windows it is read from the console
+// directly, so there is no waiting for a reply.
+func GetCursorPosition() (x, y int, err error) {
+	var info console_screen_buffer_info
+	if err := get_console_screen_buffer_info(out, &info); err != nil {
+		return 0, 0, err
+	}
+	return int(info.cursor_position.x - info.window.left),
+		int(info.cursor_position.y - info.window.top), nil
+}
+
+// Asks the terminal to identify itself. The console doesn't support the
+// queries, so on Windows this returns an error.
+func IdentifyTerminal() (TerminalInfo, error) {
+	return TerminalInfo{}, errors.New("termbox: terminal identification is not supported on windows")
+}
+
+// Asks the terminal for its default colors and palette. The console doesn't
+// support the queries, so on Windows this returns an error.
+func QueryColors() (TerminalColors, error) {
+	return TerminalColors{}, errors.New("termbox: color queries are not supported on windows")
+}
+
+// Returns what IdentifyTerminal found out about the terminal, on Windows
+// always nothing.
+func Terminal() TerminalInfo {
+	return TerminalInfo{}
+}
+
+// Sets whether Init identifies the terminal. Does nothing on Windows, returns
+// false.
+func SetIdentifyOnInit(identify bool) bool {
+	return false
+}
+
+// Sets how long the functions which query the terminal wait for the reply.
+// The console is queried directly, so on Windows this does nothing. Returns
+// the previous timeout.
+func SetQueryTimeout(d time.Duration) time.Duration {
+	if d < 0 {
+		d = 0
+	}
+	prev := query_timeout
+	query_timeout = d
+	return prev
+}
+
+// Sets the color of the cursor. Windows console doesn't support it, so at the
+// moment on Windows it does nothing.
+func SetCursorColor(color Attribute) {
+}
+
 // Changes cell's parameters in the internal back buffer at the specified
 // position.
 func SetCell(x, y int, ch rune, fg, bg Attribute) {
@@ -180,7 +293,8 @@ func SetCell(x, y int, ch rune, fg, bg Attribute) {
 		return
 	}
 
-	back_buffer.cells[y*back_buffer.width+x] = Cell{ch, fg, bg}
+	back_buffer.cells[y*back_buffer.width+x] = Cell{Ch: ch, Fg: fg, Bg: bg}
+	back_buffer.links[y*back_buffer.width+x] = nil
 }
 
 // Returns the specified cell from the internal back buffer.
@@ -227,6 +341,22 @@ func SetBg(x, y int, bg Attribute) {
 	back_buffer.cells[y*back_buffer.width+x].Bg = bg
 }
 
+// Attaches a hyperlink to the cell in the internal back buffer at the
+// specified position, nil removes it. Consecutive cells with the same link
+// are sent to the terminal as a single link. Note that SetCell resets the
+// link, so call this function after it. Windows console doesn't support
+// hyperlinks, so at the moment on Windows links are only stored.
+func SetLink(x, y int, link *Hyperlink) {
+	if x < 0 || x >= back_buffer.width {
+		return
+	}
+	if y < 0 || y >= back_buffer.height {
+		return
+	}
+
+	back_buffer.links[y*back_buffer.width+x] = link
+}
+
 // Returns a slice into the termbox's back buffer. You can get its dimensions
 // using 'Size' function. The slice remains valid as long as no 'Clear' or
 // 'Flush' function calls were made after call to this function.
@@ -244,6 +374,16 @@ func PollEvent() Event {
 	}
 }
 
+// Returns the size of a cell in pixels, which is the size of the console font,
+// or zeros if it isn't known.
+func CellSize() (int, int) {
+	var info console_font_info
+	if err := get_current_console_font(out, &info); err != nil {
+		return 0, 0
+	}
+	return int(info.font_size.x), int(info.font_size.y)
+}
+
 // Returns the size of the internal back buffer (which is mostly the same as
 // console's window size in characters). But it doesn't always match the size
 // of the console window, after the console size has changed, the internal back
@@ -269,7 +409,12 @@ func Clear(fg, bg Attribute) error {
 // any known sequence. ESC enables ModAlt modifier for the next keyboard event.
 //
 // Both input modes can be OR'ed with Mouse mode. Setting Mouse mode bit up will
-// enable mouse button press/release and drag events.
+// enable mouse button press/release and drag events, and together with Hover
+// mode, MouseHover events for the motion with no button pressed. Paste mode
+// is not supported on Windows and is ignored. Focus mode enables EventFocus
+// events.
+// Kitty, KeyRelease, ModifyOtherKeys and Pixels modes are not supported on
+// Windows and are ignored.
 //
 // If 'mode' is InputCurrent, returns the current input mode. See also Input*
 // constants.
@@ -293,6 +438,46 @@ func SetInputMode(mode InputMode) InputMode {
 	return input_mode
 }
 
+// Sets how long to wait for the rest of a partially read escape sequence. The
+// console reports keys as whole events, so on Windows this does nothing.
+// Returns the previous delay.
+func SetEscDelay(d time.Duration) time.Duration {
+	if d < 0 {
+		d = 0
+	}
+	prev := esc_delay
+	esc_delay = d
+	return prev
+}
+
+// Registers an extra input sequence for a key. The console reports keys as
+// whole events, so on Windows this does nothing.
+func RegisterKey(seq string, key Key, mod Modifier) {
+}
+
+// Removes a sequence registered with RegisterKey. Does nothing on Windows.
+func UnregisterKey(seq string) {
+}
+
+// Starts recording the terminal input. The console doesn't report the input
+// as bytes, so recording is not supported on Windows.
+func StartRecording(w io.Writer) error {
+	return errors.New("termbox: recording is not supported on windows")
+}
+
+// Stops the recording started with StartRecording. Does nothing on Windows.
+func StopRecording() error {
+	return nil
+}
+
+// Plays back a recording made with StartRecording. Not supported on Windows.
+func Replay(r io.Reader, realtime bool) error {
+	if r == nil {
+		return nil
+	}
+	return errors.New("termbox: replay is not supported on windows")
+}
+
 // Sets the termbox output mode.
 //
 // Windows console does not support extra colour modes,
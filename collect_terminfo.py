@@ -48,7 +48,32 @@ keys = [
 	"KEY_UP",	"kcuu1",
 	"KEY_DOWN",	"kcud1",
 	"KEY_LEFT",	"kcub1",
-	"KEY_RIGHT",	"kcuf1"
+	"KEY_RIGHT",	"kcuf1",
+	"F13",		"kf13",
+	"F14",		"kf14",
+	"F15",		"kf15",
+	"F16",		"kf16",
+	"F17",		"kf17",
+	"F18",		"kf18",
+	"F19",		"kf19",
+	"F20",		"kf20",
+	"F21",		"kf21",
+	"F22",		"kf22",
+	"F23",		"kf23",
+	"F24",		"kf24",
+	"BACKTAB",	"kcbt",
+	"KP_ENTER",	"kent",
+	"KP_A1",	"ka1",
+	"KP_A3",	"ka3",
+	"KP_B2",	"kb2",
+	"KP_C1",	"kc1",
+	"KP_C3",	"kc3",
+	"BEGIN",	"kbeg",
+	"MENU",		None,
+	"PRINT",	"kprt",
+	"PAUSE",	None,
+	"FIND",		"kfnd",
+	"SELECT",	"kslt"
 ]
 
 funcs = [
@@ -63,7 +88,9 @@ funcs = [
 	"T_BLINK",		"blink",
 	"T_REVERSE",            "rev",
 	"T_ENTER_KEYPAD",	"smkx",
-	"T_EXIT_KEYPAD",	"rmkx"
+	"T_EXIT_KEYPAD",	"rmkx",
+	"T_ENTER_TITLE",	"tsl",
+	"T_EXIT_TITLE",		"fsl"
 ]
 
 def iter_pairs(iterable):
@@ -79,7 +106,8 @@ def do_term(term, nick):
 	w("var %s_keys = []string{\n\t" % nick)
 	for k, v in iter_pairs(keys):
 		w('"')
-		w(escaped(tput(term, v)))
+		if v != None:
+			w(escaped(tput(term, v)))
 		w('",')
 	w("\n}\n")
 	w("var %s_funcs = []string{\n\t" % nick)
@@ -2,10 +2,9 @@
 
 package termbox
 
-// On all systems other than macOS, disable behavior which will wait before
-// deciding that the escape key was pressed, to account for partially send
-// escape sequences, especially with regard to lengthy mouse sequences.
+// On all systems other than macOS, PollEvent doesn't wait before deciding
+// that the escape key was pressed, unless asked to with SetEscDelay, to
+// account for partially sent escape sequences, especially with regard to
+// lengthy mouse sequences.
 // See https://github.com/nsf/termbox-go/issues/132
-func enable_wait_for_escape_sequence() bool {
-	return false
-}
+const default_esc_delay = 0
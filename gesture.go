@@ -0,0 +1,150 @@
+package termbox
+
+import (
+	"time"
+)
+
+const default_click_interval = 500 * time.Millisecond
+
+// Gestures turns mouse events into clicks and drags. It is optional, feed it
+// the events returned by PollEvent and handle the events it returns instead:
+//
+//	var g termbox.Gestures
+//	for {
+//		for _, ev := range g.Process(termbox.PollEvent()) {
+//			...
+//		}
+//	}
+//
+// Mouse presses and releases are passed through, and a release is followed
+// by an EventClick event, unless the mouse was dragged. Presses of the same
+// button close enough in time and place count as double and triple clicks.
+// A motion with a button pressed gives an EventDragStart event once the
+// mouse leaves the cell where the button was pressed, EventDrag events for
+// further motion, and an EventDragEnd event when the button is released.
+// All of them carry the position where the button was pressed in 'OriginX'
+// and 'OriginY'. The motion events themselves are not passed through while
+// dragging. Other events are passed through as is.
+type Gestures struct {
+	// The longest time between two presses of a double or triple click,
+	// 500ms if 0.
+	ClickInterval time.Duration
+
+	// How far (in cells, in either direction) the presses of a double or
+	// triple click may be from the first one.
+	ClickDistance int
+
+	button   Key // pressed button or 0
+	x, y     int // where it was pressed
+	dragging bool
+
+	clicks    int
+	last_key  Key
+	last_x    int
+	last_y    int
+	last_time time.Time
+
+	now func() time.Time // for tests
+}
+
+// Processes an event, returns the events to handle in its place.
+func (g *Gestures) Process(ev Event) []Event {
+	if ev.Type != EventMouse {
+		return []Event{ev}
+	}
+	switch {
+	case ev.Key == MouseRelease && ev.Mod&ModMotion == 0:
+		return g.release(ev)
+	case ev.Key == MouseHover || ev.Key == MouseRelease:
+		return []Event{ev}
+	case ev.Mod&ModMotion != 0:
+		return g.motion(ev)
+	case is_pressable(ev.Key):
+		g.press(ev)
+	}
+	return []Event{ev}
+}
+
+// Whether the button stays pressed, as opposed to wheel "buttons".
+func is_pressable(k Key) bool {
+	return k != MouseWheelUp && k != MouseWheelDown &&
+		k != MouseWheelLeft && k != MouseWheelRight
+}
+
+func (g *Gestures) press(ev Event) {
+	now := time.Now()
+	if g.now != nil {
+		now = g.now()
+	}
+	interval := g.ClickInterval
+	if interval == 0 {
+		interval = default_click_interval
+	}
+
+	if g.clicks > 0 && g.clicks < 3 && ev.Key == g.last_key &&
+		now.Sub(g.last_time) <= interval &&
+		abs(ev.MouseX-g.last_x) <= g.ClickDistance &&
+		abs(ev.MouseY-g.last_y) <= g.ClickDistance {
+		g.clicks++
+	} else {
+		g.clicks = 1
+		g.last_x, g.last_y = ev.MouseX, ev.MouseY
+	}
+	g.last_key = ev.Key
+	g.last_time = now
+
+	g.button = ev.Key
+	g.x, g.y = ev.MouseX, ev.MouseY
+	g.dragging = false
+}
+
+func (g *Gestures) motion(ev Event) []Event {
+	if g.button == 0 {
+		return []Event{ev}
+	}
+	if !g.dragging && ev.MouseX == g.x && ev.MouseY == g.y {
+		return []Event{ev}
+	}
+
+	gev := g.gesture(ev, EventDrag)
+	if !g.dragging {
+		g.dragging = true
+		// a drag is not a click
+		g.clicks = 0
+		gev.Type = EventDragStart
+	}
+	return []Event{gev}
+}
+
+func (g *Gestures) release(ev Event) []Event {
+	if g.button == 0 {
+		return []Event{ev}
+	}
+
+	var gev Event
+	if g.dragging {
+		gev = g.gesture(ev, EventDragEnd)
+	} else {
+		gev = g.gesture(ev, EventClick)
+		gev.Clicks = g.clicks
+	}
+	g.button = 0
+	g.dragging = false
+	return []Event{ev, gev}
+}
+
+func (g *Gestures) gesture(ev Event, t EventType) Event {
+	ev.Type = t
+	ev.Key = g.button
+	ev.Mod &^= ModMotion
+	ev.Button = 0
+	ev.OriginX, ev.OriginY = g.x, g.y
+	return ev
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,51 @@
+package termbox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGestures(t *testing.T) {
+	now := time.Unix(0, 0)
+	g := Gestures{now: func() time.Time { return now }}
+	mouse := func(key Key, mod Modifier, x, y int) Event {
+		return Event{Type: EventMouse, Key: key, Mod: mod, MouseX: x, MouseY: y}
+	}
+	last := func(evs []Event) Event {
+		return evs[len(evs)-1]
+	}
+
+	// single, double and triple click
+	for i := 1; i <= 3; i++ {
+		g.Process(mouse(MouseLeft, 0, 5, 5))
+		ev := last(g.Process(mouse(MouseRelease, 0, 5, 5)))
+		if ev.Type != EventClick || ev.Clicks != i || ev.Key != MouseLeft {
+			t.Errorf("click %d: got %+v", i, ev)
+		}
+		now = now.Add(100 * time.Millisecond)
+	}
+
+	// too late for a double click
+	now = now.Add(time.Second)
+	g.Process(mouse(MouseLeft, 0, 5, 5))
+	if ev := last(g.Process(mouse(MouseRelease, 0, 5, 5))); ev.Clicks != 1 {
+		t.Errorf("late click: got %+v", ev)
+	}
+
+	// drag
+	g.Process(mouse(MouseLeft, 0, 1, 2))
+	if ev := last(g.Process(mouse(MouseLeft, ModMotion, 1, 2))); ev.Type != EventMouse {
+		t.Errorf("motion within the cell: got %+v", ev)
+	}
+	want := []EventType{EventDragStart, EventDrag, EventDragEnd}
+	evs := []Event{
+		last(g.Process(mouse(MouseLeft, ModMotion, 2, 2))),
+		last(g.Process(mouse(MouseLeft, ModMotion, 3, 4))),
+		last(g.Process(mouse(MouseRelease, 0, 3, 4))),
+	}
+	for i, ev := range evs {
+		if ev.Type != want[i] || ev.Key != MouseLeft || ev.OriginX != 1 || ev.OriginY != 2 {
+			t.Errorf("drag %d: got %+v", i, ev)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+// Package keymap maps key presses and chords to actions, using textual key
+// specs like "ctrl+x ctrl+s", "alt+shift+up" or "<F5>".
+//
+// A spec is a list of strokes separated by spaces. A stroke is a key with
+// optional modifiers joined with '+': ctrl, alt, shift, meta, super and
+// hyper. The key is either a single character ("a", "?", "plus" for '+') or
+// a key name as accepted by termbox.Key.UnmarshalText ("F5", "PgUp",
+// "Enter", ...), case insensitive. Any stroke may be wrapped in angle
+// brackets.
+//
+//	var km keymap.Keymap
+//	km.Timeout = time.Second
+//	km.Bind("ctrl+x ctrl+s", save)
+//	km.Bind("<F5>", refresh)
+//	...
+//	if action, res := km.Handle(termbox.PollEvent()); res == keymap.Matched {
+//		action.(func())()
+//	}
+package keymap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/nsf/termbox-go"
+)
+
+// A single key press: either a key or a character, with modifiers.
+type Stroke struct {
+	Key termbox.Key
+	Ch  rune
+	Mod termbox.Modifier
+}
+
+// A sequence of strokes, a chord.
+type Seq []Stroke
+
+var mod_names = []struct {
+	mod  termbox.Modifier
+	name string
+}{
+	{termbox.ModCtrl, "ctrl"},
+	{termbox.ModAlt, "alt"},
+	{termbox.ModShift, "shift"},
+	{termbox.ModMeta, "meta"},
+	{termbox.ModSuper, "super"},
+	{termbox.ModHyper, "hyper"},
+}
+
+var key_aliases = map[string]termbox.Key{
+	"escape":   termbox.KeyEsc,
+	"return":   termbox.KeyEnter,
+	"pageup":   termbox.KeyPgup,
+	"pagedown": termbox.KeyPgdn,
+	"ins":      termbox.KeyInsert,
+	"del":      termbox.KeyDelete,
+}
+
+// Whether the key is a control code, which legacy terminals report without
+// ModCtrl.
+func is_control(k termbox.Key) bool {
+	return k < termbox.KeySpace || k == termbox.KeyBackspace2
+}
+
+// Parses a single stroke, like "ctrl+x", "alt+shift+up" or "<F5>".
+func ParseStroke(s string) (Stroke, error) {
+	spec := s
+	if len(s) > 2 && s[0] == '<' && s[len(s)-1] == '>' {
+		s = s[1 : len(s)-1]
+	}
+
+	var st Stroke
+	for {
+		i := strings.IndexByte(s, '+')
+		if i <= 0 || i == len(s)-1 {
+			// no more modifiers, a '+' at either end is the key
+			break
+		}
+		name := s[:i]
+		found := false
+		for _, m := range mod_names {
+			if strings.EqualFold(name, m.name) {
+				st.Mod |= m.mod
+				found = true
+				break
+			}
+		}
+		if !found {
+			return Stroke{}, fmt.Errorf("keymap: unknown modifier %q in %q", name, spec)
+		}
+		s = s[i+1:]
+	}
+
+	if strings.EqualFold(s, "plus") {
+		s = "+"
+	}
+	if ch, size := utf8.DecodeRuneInString(s); size == len(s) && ch != utf8.RuneError {
+		return char_stroke(st.Mod, ch), nil
+	}
+	if strings.EqualFold(s, "space") {
+		if st.Mod&termbox.ModCtrl != 0 {
+			st.Key = termbox.KeyCtrlSpace
+		} else {
+			st.Key = termbox.KeySpace
+		}
+		return st, nil
+	}
+	if k, ok := key_aliases[strings.ToLower(s)]; ok {
+		st.Key = k
+		return st, nil
+	}
+	if err := st.Key.UnmarshalText([]byte(s)); err != nil {
+		return Stroke{}, fmt.Errorf("keymap: unknown key %q in %q", s, spec)
+	}
+	return st, nil
+}
+
+// The stroke of a character with modifiers, the way termbox reports it:
+// Shift is a part of the character, and Ctrl with a letter is a control key.
+func char_stroke(mod termbox.Modifier, ch rune) Stroke {
+	if mod&termbox.ModShift != 0 && unicode.IsLower(ch) {
+		ch = unicode.ToUpper(ch)
+	}
+	if mod&termbox.ModCtrl != 0 {
+		if k, ok := termbox.CtrlKey(ch); ok {
+			if unicode.IsUpper(ch) {
+				// Ctrl+Shift+letter, as reported in the modifyOtherKeys
+				// and Kitty input modes
+				return Stroke{Key: k, Mod: mod | termbox.ModShift}
+			}
+			return Stroke{Key: k, Mod: mod}
+		}
+	}
+	return Stroke{Ch: ch, Mod: mod &^ termbox.ModShift}
+}
+
+// Parses a key spec, strokes separated by spaces.
+func Parse(spec string) (Seq, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, errors.New("keymap: empty key spec")
+	}
+	seq := make(Seq, len(fields))
+	for i, f := range fields {
+		var err error
+		seq[i], err = ParseStroke(f)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return seq, nil
+}
+
+// Like Parse, but panics on errors. For specs known to be valid.
+func MustParse(spec string) Seq {
+	seq, err := Parse(spec)
+	if err != nil {
+		panic(err)
+	}
+	return seq
+}
+
+// Returns the canonical spec of the stroke, which ParseStroke accepts.
+func (st Stroke) String() string {
+	mod := st.Mod
+	var key string
+	switch {
+	case st.Ch == '+':
+		key = "plus"
+	case st.Ch == ' ':
+		key = "space"
+	case st.Ch != 0:
+		key = string(st.Ch)
+		if unicode.IsUpper(st.Ch) {
+			mod &^= termbox.ModShift
+		}
+	case st.Key >= termbox.KeyCtrlA && st.Key <= termbox.KeyCtrlZ &&
+		st.Key != termbox.KeyBackspace && st.Key != termbox.KeyTab &&
+		st.Key != termbox.KeyEnter:
+		mod |= termbox.ModCtrl
+		key = string(rune('a' + st.Key - termbox.KeyCtrlA))
+	case is_control(st.Key) && st.Key != termbox.KeyEsc && st.Key != termbox.KeyBackspace2 &&
+		st.Key != termbox.KeyBackspace && st.Key != termbox.KeyTab && st.Key != termbox.KeyEnter:
+		// Ctrl+Space, Ctrl+\ and the like
+		mod |= termbox.ModCtrl
+		key = strings.ToLower(strings.TrimPrefix(st.Key.String(), "Ctrl+"))
+	default:
+		key = strings.ToLower(st.Key.String())
+	}
+
+	var b strings.Builder
+	for _, m := range mod_names {
+		if mod&m.mod != 0 {
+			b.WriteString(m.name)
+			b.WriteByte('+')
+		}
+	}
+	b.WriteString(key)
+	return b.String()
+}
+
+// Implements encoding.TextMarshaler.
+func (st Stroke) MarshalText() ([]byte, error) {
+	return []byte(st.String()), nil
+}
+
+// Implements encoding.TextUnmarshaler.
+func (st *Stroke) UnmarshalText(text []byte) error {
+	s, err := ParseStroke(string(text))
+	if err != nil {
+		return err
+	}
+	*st = s
+	return nil
+}
+
+// Whether the event is a press of the stroke's key. A control key without
+// ModCtrl matches a stroke with it, because that's how terminals without the
+// modifyOtherKeys or Kitty input modes report them. Shift is ignored for
+// characters, it is a part of the character.
+func (st Stroke) Matches(ev termbox.Event) bool {
+	if ev.Type != termbox.EventKey && ev.Type != termbox.EventMouse {
+		return false
+	}
+	if ev.Action == termbox.KeyRelease {
+		return false
+	}
+	mod := ev.Mod &^ termbox.ModMotion
+	if st.Ch != 0 || ev.Ch != 0 {
+		return ev.Ch == st.Ch && mod&^termbox.ModShift == st.Mod&^termbox.ModShift
+	}
+	if ev.Key != st.Key {
+		return false
+	}
+	if mod == st.Mod {
+		return true
+	}
+	return is_control(st.Key) && mod&termbox.ModCtrl == 0 && mod|termbox.ModCtrl == st.Mod
+}
+
+// Returns the canonical spec of the sequence.
+func (seq Seq) String() string {
+	parts := make([]string, len(seq))
+	for i, st := range seq {
+		parts[i] = st.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+// Implements encoding.TextMarshaler.
+func (seq Seq) MarshalText() ([]byte, error) {
+	return []byte(seq.String()), nil
+}
+
+// Implements encoding.TextUnmarshaler.
+func (seq *Seq) UnmarshalText(text []byte) error {
+	s, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*seq = s
+	return nil
+}
+
+func (seq Seq) equal(other Seq) bool {
+	if len(seq) != len(other) {
+		return false
+	}
+	for i := range seq {
+		if seq[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// The result of Keymap.Handle.
+type Result int
+
+const (
+	NoMatch Result = iota // the event isn't bound to anything
+	Pending               // the event started or continued a chord
+	Matched               // the event completed a binding
+)
+
+type binding struct {
+	seq    Seq
+	action interface{}
+}
+
+// A set of bindings of key sequences to actions, with the state of the chord
+// being typed. The zero value is an empty keymap ready to use.
+type Keymap struct {
+	// The longest time allowed between the strokes of a chord. When it
+	// passes, the chord typed so far is dropped. 0 means no limit.
+	Timeout time.Duration
+
+	bindings []binding
+	pending  []termbox.Event
+	last     time.Time
+
+	now func() time.Time // for tests
+}
+
+// Binds the key spec to the action, replacing the previous binding of the
+// same spec. A spec which starts with a bound one, or which a bound one starts
+// with, like "ctrl+x" and "ctrl+x ctrl+s", is an error: the chord couldn't
+// tell which of the two is meant.
+func (km *Keymap) Bind(spec string, action interface{}) error {
+	seq, err := Parse(spec)
+	if err != nil {
+		return err
+	}
+	return km.BindSeq(seq, action)
+}
+
+// Same as Bind, for an already parsed sequence.
+func (km *Keymap) BindSeq(seq Seq, action interface{}) error {
+	if len(seq) == 0 {
+		return errors.New("keymap: empty key sequence")
+	}
+	for i := range km.bindings {
+		b := &km.bindings[i]
+		if b.seq.equal(seq) {
+			b.action = action
+			return nil
+		}
+		if n := min_len(b.seq, seq); b.seq[:n].equal(seq[:n]) {
+			return fmt.Errorf("keymap: %q conflicts with the bound %q", seq, b.seq)
+		}
+	}
+	km.bindings = append(km.bindings, binding{seq, action})
+	return nil
+}
+
+func min_len(a, b Seq) int {
+	if len(a) < len(b) {
+		return len(a)
+	}
+	return len(b)
+}
+
+// Removes the binding of the key spec.
+func (km *Keymap) Unbind(spec string) error {
+	seq, err := Parse(spec)
+	if err != nil {
+		return err
+	}
+	for i := range km.bindings {
+		if km.bindings[i].seq.equal(seq) {
+			km.bindings = append(km.bindings[:i], km.bindings[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Drops the chord typed so far.
+func (km *Keymap) Reset() {
+	km.pending = km.pending[:0]
+}
+
+// Returns the strokes of the chord typed so far, e.g. to show "ctrl+x -" in a
+// status line, or nil if there is none.
+func (km *Keymap) Pending() Seq {
+	if len(km.pending) == 0 {
+		return nil
+	}
+	for _, b := range km.bindings {
+		if b.matches(km.pending) {
+			return append(Seq(nil), b.seq[:len(km.pending)]...)
+		}
+	}
+	return nil
+}
+
+// Whether the events match the beginning of the binding.
+func (b *binding) matches(evs []termbox.Event) bool {
+	if len(evs) > len(b.seq) {
+		return false
+	}
+	for i, ev := range evs {
+		if !b.seq[i].Matches(ev) {
+			return false
+		}
+	}
+	return true
+}
+
+// Handles an event. If it completes a binding, returns its action and
+// Matched. If it is a prefix of a chord, returns Pending, the keymap then
+// waits for the next stroke. Otherwise returns NoMatch. A stroke which
+// doesn't continue the chord typed so far drops it and is handled on its own.
+// Events other than key presses and mouse events return NoMatch and don't
+// affect the chord.
+func (km *Keymap) Handle(ev termbox.Event) (interface{}, Result) {
+	if ev.Type != termbox.EventKey && ev.Type != termbox.EventMouse ||
+		ev.Action == termbox.KeyRelease {
+		return nil, NoMatch
+	}
+	if ev.Type == termbox.EventMouse && ev.Mod&termbox.ModMotion != 0 {
+		return nil, NoMatch
+	}
+
+	now := time.Now()
+	if km.now != nil {
+		now = km.now()
+	}
+	if len(km.pending) != 0 && km.Timeout != 0 && now.Sub(km.last) > km.Timeout {
+		km.Reset()
+	}
+
+	evs := append(km.pending, ev)
+	prefix := false
+	for i := range km.bindings {
+		b := &km.bindings[i]
+		if !b.matches(evs) {
+			continue
+		}
+		if len(b.seq) == len(evs) {
+			km.Reset()
+			return b.action, Matched
+		}
+		prefix = true
+	}
+	if prefix {
+		km.pending = evs
+		km.last = now
+		return nil, Pending
+	}
+	if len(km.pending) != 0 {
+		// the stroke broke the chord, but it may start a binding of its own
+		km.Reset()
+		return km.Handle(ev)
+	}
+	return nil, NoMatch
+}
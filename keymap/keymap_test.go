@@ -0,0 +1,132 @@
+package keymap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		spec  string
+		seq   Seq
+		canon string
+	}{
+		{"ctrl+x ctrl+s", Seq{{Key: termbox.KeyCtrlX, Mod: termbox.ModCtrl}, {Key: termbox.KeyCtrlS, Mod: termbox.ModCtrl}}, "ctrl+x ctrl+s"},
+		{"alt+shift+up", Seq{{Key: termbox.KeyArrowUp, Mod: termbox.ModAlt | termbox.ModShift}}, "alt+shift+up"},
+		{"<F5>", Seq{{Key: termbox.KeyF5}}, "f5"},
+		{"Ctrl+Space", Seq{{Key: termbox.KeyCtrlSpace, Mod: termbox.ModCtrl}}, "ctrl+space"},
+		{"ctrl+\\", Seq{{Key: termbox.KeyCtrlBackslash, Mod: termbox.ModCtrl}}, "ctrl+\\"},
+		{"shift+a", Seq{{Ch: 'A'}}, "A"},
+		{"alt++", Seq{{Ch: '+', Mod: termbox.ModAlt}}, "alt+plus"},
+		{"g g", Seq{{Ch: 'g'}, {Ch: 'g'}}, "g g"},
+		{"escape", Seq{{Key: termbox.KeyEsc}}, "esc"},
+		{"ctrl+pgdn", Seq{{Key: termbox.KeyPgdn, Mod: termbox.ModCtrl}}, "ctrl+pgdn"},
+		{"ctrl+2", Seq{{Ch: '2', Mod: termbox.ModCtrl}}, "ctrl+2"},
+	}
+	for _, c := range cases {
+		seq, err := Parse(c.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", c.spec, err)
+			continue
+		}
+		if !seq.equal(c.seq) {
+			t.Errorf("Parse(%q) = %v, want %v", c.spec, seq, c.seq)
+		}
+		if s := seq.String(); s != c.canon {
+			t.Errorf("Parse(%q).String() = %q, want %q", c.spec, s, c.canon)
+		}
+		var back Seq
+		if err := back.UnmarshalText([]byte(seq.String())); err != nil || !back.equal(seq) {
+			t.Errorf("%q does not round trip: %v, %v", c.spec, back, err)
+		}
+	}
+
+	for _, spec := range []string{"", "foo+x", "ctrl+nokey"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestKeymap(t *testing.T) {
+	now := time.Unix(0, 0)
+	km := Keymap{Timeout: time.Second, now: func() time.Time { return now }}
+	km.Bind("ctrl+x ctrl+s", "save")
+	km.Bind("ctrl+x k", "kill")
+	km.Bind("q", "quit")
+
+	key := func(k termbox.Key) termbox.Event { return termbox.Event{Type: termbox.EventKey, Key: k} }
+	ch := func(c rune) termbox.Event { return termbox.Event{Type: termbox.EventKey, Ch: c} }
+
+	check := func(ev termbox.Event, action interface{}, res Result) {
+		t.Helper()
+		a, r := km.Handle(ev)
+		if a != action || r != res {
+			t.Errorf("Handle(%v) = %v, %v, want %v, %v", ev, a, r, action, res)
+		}
+	}
+
+	check(ch('q'), "quit", Matched)
+	check(key(termbox.KeyCtrlX), nil, Pending)
+	if p := km.Pending().String(); p != "ctrl+x" {
+		t.Errorf("Pending() = %q", p)
+	}
+	check(termbox.Event{Type: termbox.EventResize}, nil, NoMatch)
+	check(key(termbox.KeyCtrlS), "save", Matched)
+	check(key(termbox.KeyCtrlX), nil, Pending)
+	check(ch('k'), "kill", Matched)
+
+	// a stroke that is not bound drops the chord
+	check(key(termbox.KeyCtrlX), nil, Pending)
+	check(ch('z'), nil, NoMatch)
+	check(key(termbox.KeyCtrlS), nil, NoMatch)
+
+	// and a stroke that is bound on its own still works
+	check(key(termbox.KeyCtrlX), nil, Pending)
+	check(ch('q'), "quit", Matched)
+	check(key(termbox.KeyCtrlX), nil, Pending)
+	check(key(termbox.KeyCtrlX), nil, Pending)
+	check(ch('k'), "kill", Matched)
+
+	// so does the timeout
+	check(key(termbox.KeyCtrlX), nil, Pending)
+	now = now.Add(2 * time.Second)
+	check(key(termbox.KeyCtrlS), nil, NoMatch)
+
+	km.Bind("q", "quit2")
+	check(ch('q'), "quit2", Matched)
+	km.Unbind("q")
+	check(ch('q'), nil, NoMatch)
+}
+
+func TestKeymapConflicts(t *testing.T) {
+	// whichever is bound first, the other one is rejected
+	orders := [][2]string{
+		{"ctrl+x", "ctrl+x ctrl+s"},
+		{"ctrl+x ctrl+s", "ctrl+x"},
+	}
+	for _, specs := range orders {
+		var km Keymap
+		if err := km.Bind(specs[0], "first"); err != nil {
+			t.Fatal(err)
+		}
+		if err := km.Bind(specs[1], "second"); err == nil {
+			t.Errorf("%q after %q: want an error", specs[1], specs[0])
+		}
+
+		// and the first one works as before
+		var got interface{}
+		for _, k := range []termbox.Key{termbox.KeyCtrlX, termbox.KeyCtrlS} {
+			a, r := km.Handle(termbox.Event{Type: termbox.EventKey, Key: k})
+			if r == Matched {
+				got = a
+				break
+			}
+		}
+		if got != "first" {
+			t.Errorf("%q bound first: got %v", specs[0], got)
+		}
+	}
+}
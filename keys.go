@@ -0,0 +1,171 @@
+package termbox
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Names of the keys, used by Key.String and Key.UnmarshalText. A slice, so
+// that the names are looked up in a fixed order.
+var key_names = []struct {
+	key  Key
+	name string
+}{
+	{KeyF1, "F1"},
+	{KeyF2, "F2"},
+	{KeyF3, "F3"},
+	{KeyF4, "F4"},
+	{KeyF5, "F5"},
+	{KeyF6, "F6"},
+	{KeyF7, "F7"},
+	{KeyF8, "F8"},
+	{KeyF9, "F9"},
+	{KeyF10, "F10"},
+	{KeyF11, "F11"},
+	{KeyF12, "F12"},
+	{KeyInsert, "Insert"},
+	{KeyDelete, "Delete"},
+	{KeyHome, "Home"},
+	{KeyEnd, "End"},
+	{KeyPgup, "PgUp"},
+	{KeyPgdn, "PgDn"},
+	{KeyArrowUp, "Up"},
+	{KeyArrowDown, "Down"},
+	{KeyArrowLeft, "Left"},
+	{KeyArrowRight, "Right"},
+	{KeyF13, "F13"},
+	{KeyF14, "F14"},
+	{KeyF15, "F15"},
+	{KeyF16, "F16"},
+	{KeyF17, "F17"},
+	{KeyF18, "F18"},
+	{KeyF19, "F19"},
+	{KeyF20, "F20"},
+	{KeyF21, "F21"},
+	{KeyF22, "F22"},
+	{KeyF23, "F23"},
+	{KeyF24, "F24"},
+	{KeyBacktab, "Backtab"},
+	{KeyKeypadEnter, "KeypadEnter"},
+	{KeyKeypadA1, "KeypadA1"},
+	{KeyKeypadA3, "KeypadA3"},
+	{KeyKeypadB2, "KeypadB2"},
+	{KeyKeypadC1, "KeypadC1"},
+	{KeyKeypadC3, "KeypadC3"},
+	{KeyBegin, "Begin"},
+	{KeyMenu, "Menu"},
+	{KeyPrint, "Print"},
+	{KeyPause, "Pause"},
+	{KeyFind, "Find"},
+	{KeySelect, "Select"},
+	{MouseLeft, "MouseLeft"},
+	{MouseMiddle, "MouseMiddle"},
+	{MouseRight, "MouseRight"},
+	{MouseRelease, "MouseRelease"},
+	{MouseWheelUp, "MouseWheelUp"},
+	{MouseWheelDown, "MouseWheelDown"},
+	{MouseWheelLeft, "MouseWheelLeft"},
+	{MouseWheelRight, "MouseWheelRight"},
+	{MouseButton4, "MouseButton4"},
+	{MouseButton5, "MouseButton5"},
+	{MouseButton6, "MouseButton6"},
+	{MouseButton7, "MouseButton7"},
+	{MouseButton8, "MouseButton8"},
+	{MouseHover, "MouseHover"},
+
+	{KeyCtrlSpace, "Ctrl+Space"},
+	{KeyBackspace, "Backspace"},
+	{KeyTab, "Tab"},
+	{KeyEnter, "Enter"},
+	{KeyEsc, "Esc"},
+	{KeyCtrlBackslash, "Ctrl+\\"},
+	{KeyCtrlRsqBracket, "Ctrl+]"},
+	{KeyCtrl6, "Ctrl+6"},
+	{KeyCtrlSlash, "Ctrl+/"},
+	{KeySpace, "Space"},
+	{KeyBackspace2, "Backspace2"},
+}
+
+// Returns the name of the key, e.g. "F1", "PgUp" or "Ctrl+A". Custom keys
+// (see KeyUser) are "User+N", other unknown keys are "Key(N)".
+func (k Key) String() string {
+	for _, kn := range key_names {
+		if kn.key == k {
+			return kn.name
+		}
+	}
+	switch {
+	case k >= KeyCtrlA && k <= KeyCtrlZ:
+		return "Ctrl+" + string(rune('A'+k-KeyCtrlA))
+	case k >= KeyUser && k < KeyUser+0x4000:
+		return "User+" + strconv.Itoa(int(k-KeyUser))
+	}
+	return fmt.Sprintf("Key(%d)", int(k))
+}
+
+// Implements encoding.TextMarshaler, the text is the same as from String.
+func (k Key) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// Implements encoding.TextUnmarshaler, accepts what MarshalText returns. Key
+// names are case insensitive.
+func (k *Key) UnmarshalText(text []byte) error {
+	s := string(text)
+	for _, kn := range key_names {
+		if strings.EqualFold(s, kn.name) {
+			*k = kn.key
+			return nil
+		}
+	}
+
+	var n int
+	var err error
+	switch {
+	case len(s) == 6 && strings.EqualFold(s[:5], "Ctrl+") &&
+		(s[5] >= 'a' && s[5] <= 'z' || s[5] >= 'A' && s[5] <= 'Z'):
+		*k = KeyCtrlA + Key(s[5]|0x20-'a')
+		return nil
+	case strings.HasPrefix(s, "User+"):
+		n, err = strconv.Atoi(s[5:])
+		if err == nil && n >= 0 && n < 0x4000 {
+			*k = KeyUser + Key(n)
+			return nil
+		}
+	case strings.HasPrefix(s, "Key(") && strings.HasSuffix(s, ")"):
+		n, err = strconv.Atoi(s[4 : len(s)-1])
+		if err == nil && n >= 0 && n <= 0xFFFF {
+			*k = Key(n)
+			return nil
+		}
+	}
+	return fmt.Errorf("termbox: unknown key %q", s)
+}
+
+// Returns the legacy control key of a character pressed with Ctrl, the way
+// termbox reports it, e.g. KeyCtrlA for 'a' or 'A' and KeyCtrlBackslash for
+// '\\'. Returns false for the characters which are reported as themselves
+// with ModCtrl instead, like digits: Ctrl+2 is '2' with ModCtrl rather than
+// KeyCtrl2.
+func CtrlKey(ch rune) (Key, bool) {
+	switch {
+	case ch >= 'a' && ch <= 'z':
+		return Key(ch-'a') + KeyCtrlA, true
+	case ch >= 'A' && ch <= 'Z':
+		return Key(ch-'A') + KeyCtrlA, true
+	case ch == ' ' || ch == '@' || ch == '~' || ch == '`':
+		return KeyCtrlSpace, true
+	case ch == '[':
+		return KeyCtrlLsqBracket, true
+	case ch == '\\':
+		return KeyCtrlBackslash, true
+	case ch == ']':
+		return KeyCtrlRsqBracket, true
+	case ch == '^':
+		return KeyCtrl6, true
+	case ch == '_' || ch == '/':
+		return KeyCtrlSlash, true
+	}
+	return 0, false
+}
@@ -0,0 +1,524 @@
+// +build !windows
+
+package termbox
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Queries to the terminal, whose replies arrive along with the input. The
+// parser only takes a sequence for a reply while a query of its kind is
+// pending, so that e.g. CSI 1;5R is still Ctrl+F3 the rest of the time. See
+// query and take_reply.
+type query_kind int
+
+const (
+	query_cursor    query_kind = iota
+	query_xtversion            // CSI > 0 q, the name and version
+	query_da2                  // CSI > c, secondary device attributes
+	query_da1                  // CSI c, primary device attributes
+	query_color                // OSC 10, 11 and 4, the default colors and the palette
+	query_kitty                // CSI ? u, the kitty keyboard protocol flags
+	query_cell_size            // CSI 16 t, the cell size in pixels
+	query_clipboard            // OSC 52, the clipboard contents, see GetClipboard
+	query_kinds
+)
+
+// How long the replies are still taken after the query gave up on them.
+const late_reply_grace = 500 * time.Millisecond
+
+// The identification queries. Terminals reply in order and all of them reply
+// to DA1, so the DA1 reply means that there won't be any more.
+const identify_queries = "\033[>0q\033[>c\033[c"
+
+var (
+	query_mu      sync.Mutex
+	query_pending [query_kinds]int
+	reply_comm    [query_kinds]chan string
+
+	// until when the replies to the pending queries are taken, a bit longer
+	// than query_timeout, so that late replies don't leak as keys
+	query_expiry [query_kinds]time.Time
+
+	// the queries in outbuf, which become pending when it is flushed, see
+	// queue_query
+	queued_queries []query_kind
+
+	// whether the next Flush should ask for the cell size, see
+	// update_cell_size
+	cell_size_wanted bool
+
+	// how long to wait for a reply, see SetQueryTimeout
+	query_timeout = time.Second
+
+	// whoever reads the input holds the token: PollEvent, or a query
+	// waiting for its reply while PollEvent isn't running, which keeps the
+	// events it reads along the way in event_queue for PollEvent
+	poll_token  = make(chan struct{}, 1)
+	event_queue []Event
+
+	// see IdentifyTerminal and QueryColors, guarded by query_mu
+	terminal_info    TerminalInfo
+	terminal_colors  TerminalColors
+	identify_on_init bool
+)
+
+func init() {
+	for i := range reply_comm {
+		reply_comm[i] = make(chan string, 1)
+	}
+}
+
+// Returns the kind of the reply in 'seq', a complete escape sequence.
+func reply_kind(seq []byte) (query_kind, bool) {
+	if _, _, ok := parse_cursor_report(seq); ok {
+		return query_cursor, true
+	}
+	if _, _, ok := parse_cell_size_report(seq); ok {
+		return query_cell_size, true
+	}
+	switch {
+	case bytes.HasPrefix(seq, []byte("\033P>|")):
+		return query_xtversion, true
+	case bytes.HasPrefix(seq, []byte("\033]10;")) || bytes.HasPrefix(seq, []byte("\033]11;")) ||
+		bytes.HasPrefix(seq, []byte("\033]4;")):
+		return query_color, true
+	case bytes.HasPrefix(seq, []byte("\033[?")) && seq[len(seq)-1] == 'u':
+		return query_kitty, true
+	case seq[len(seq)-1] != 'c':
+	case bytes.HasPrefix(seq, []byte("\033[>")):
+		return query_da2, true
+	case bytes.HasPrefix(seq, []byte("\033[?")):
+		return query_da1, true
+	}
+	return 0, false
+}
+
+// Counts the queries of the kinds as sent. Must be called with query_mu held.
+func add_pending(kinds ...query_kind) {
+	expiry := time.Now().Add(query_timeout + late_reply_grace)
+	for _, kind := range kinds {
+		query_pending[kind]++
+		if expiry.After(query_expiry[kind]) {
+			query_expiry[kind] = expiry
+		}
+	}
+}
+
+// Forgets the queries which didn't get a reply in time. Must be called with
+// query_mu held.
+func expire_queries() {
+	now := time.Now()
+	for kind := range query_pending {
+		if query_pending[kind] > 0 && now.After(query_expiry[kind]) {
+			query_pending[kind] = 0
+		}
+	}
+}
+
+// Records the queries just written to outbuf, the next flush sends them and
+// counts them as pending, so that their time runs from then on.
+func queue_query(kinds ...query_kind) {
+	query_mu.Lock()
+	queued_queries = append(queued_queries, kinds...)
+	query_mu.Unlock()
+}
+
+// Counts the queries in outbuf as pending, flush calls it right before it
+// sends them.
+func send_queued_queries() {
+	query_mu.Lock()
+	add_pending(queued_queries...)
+	queued_queries = queued_queries[:0]
+	query_mu.Unlock()
+}
+
+// Counts a reply which the parser turned into an event rather than taking it
+// with take_reply, like the clipboard contents.
+func reply_arrived(kind query_kind) {
+	query_mu.Lock()
+	expire_queries()
+	if query_pending[kind] > 0 {
+		query_pending[kind]--
+	}
+	query_mu.Unlock()
+}
+
+// Whether a reply of the kind is still expected.
+func reply_pending(kind query_kind) bool {
+	query_mu.Lock()
+	defer query_mu.Unlock()
+	expire_queries()
+	return query_pending[kind] > 0
+}
+
+// Returns until when a reply may still arrive, or the zero time if no query
+// is waiting for one.
+func query_deadline() time.Time {
+	query_mu.Lock()
+	defer query_mu.Unlock()
+	expire_queries()
+	var deadline time.Time
+	for kind, n := range query_pending {
+		if n > 0 && query_expiry[kind].After(deadline) {
+			deadline = query_expiry[kind]
+		}
+	}
+	return deadline
+}
+
+// Whether the partially read sequence may still become a reply to one of the
+// pending queries.
+func reply_prefix(buf []byte) bool {
+	if len(buf) < 2 {
+		// a bare ESC may as well be the Esc key, the intro decides
+		return false
+	}
+	query_mu.Lock()
+	defer query_mu.Unlock()
+	expire_queries()
+	for kind, n := range query_pending {
+		if n == 0 {
+			continue
+		}
+		for _, f := range reply_forms[kind] {
+			if len(buf) <= len(f.intro) {
+				if bytes.HasPrefix([]byte(f.intro), buf) {
+					return true
+				}
+				continue
+			}
+			if !bytes.HasPrefix(buf, []byte(f.intro)) {
+				continue
+			}
+			rest := buf[len(f.intro):]
+			if !f.params || len(bytes.TrimLeft(rest, "0123456789;")) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// The beginnings of the replies of each kind, see reply_prefix. The replies
+// with 'params' continue with numeric params only, until the final byte.
+var reply_forms = [query_kinds][]struct {
+	intro  string
+	params bool
+}{
+	query_cursor:    {{"\033[", true}},
+	query_xtversion: {{"\033P>|", false}},
+	query_da2:       {{"\033[>", true}},
+	query_da1:       {{"\033[?", true}},
+	query_color:     {{"\033]10;", false}, {"\033]11;", false}, {"\033]4;", false}},
+	query_kitty:     {{"\033[?", true}},
+	query_cell_size: {{"\033[6;", true}},
+}
+
+// Takes the sequence if it is a reply to a pending query and passes it on to
+// the query. Returns false for anything else.
+func take_reply(seq []byte) bool {
+	kind, ok := reply_kind(seq)
+	if !ok {
+		return false
+	}
+	query_mu.Lock()
+	expire_queries()
+	pending := query_pending[kind] > 0
+	if pending {
+		query_pending[kind]--
+		switch kind {
+		case query_color:
+			parse_color_reply(&terminal_colors, string(seq))
+		case query_kitty:
+			kitty_supported = true
+		case query_cell_size:
+			cell_w, cell_h, _ = parse_cell_size_report(seq)
+		default:
+			parse_identity(&terminal_info, kind, string(seq))
+		}
+		if kind == query_da1 {
+			// the terminal doesn't know the queries sent before DA1
+			for _, k := range []query_kind{query_xtversion, query_da2, query_color, query_kitty, query_cell_size} {
+				if query_pending[k] > query_pending[query_da1] {
+					query_pending[k] = query_pending[query_da1]
+				}
+			}
+		}
+	}
+	query_mu.Unlock()
+	if !pending {
+		return false
+	}
+	select {
+	case reply_comm[kind] <- string(seq):
+	default:
+	}
+	return true
+}
+
+// Sends the query and waits for the reply of the last of the kinds, up to
+// query_timeout. Works both while another goroutine is in PollEvent, which
+// then passes the reply on, and without one, reading the input here.
+func query(seq string, kinds ...query_kind) (string, bool) {
+	last := kinds[len(kinds)-1]
+	// drop a late reply to an earlier query
+	select {
+	case <-reply_comm[last]:
+	default:
+	}
+	query_mu.Lock()
+	add_pending(kinds...)
+	query_mu.Unlock()
+
+	if _, err := out.WriteString(seq); err != nil {
+		cancel_query(kinds)
+		return "", false
+	}
+
+	timer := time.NewTimer(query_timeout)
+	defer timer.Stop()
+	token := poll_token
+	var input chan input_event
+	defer func() {
+		if input != nil {
+			<-poll_token
+		}
+	}()
+	for {
+		select {
+		case reply := <-reply_comm[last]:
+			return reply, true
+		case token <- struct{}{}:
+			token = nil
+			input = input_comm
+			queue_events()
+		case ev := <-input:
+			if ev.err != nil {
+				event_queue = append(event_queue, Event{Type: EventError, Err: ev.err})
+			} else {
+				if recording != nil {
+					recording.input(ev.data)
+				}
+				inbuf = append(inbuf, ev.data...)
+			}
+			input_comm <- ev
+			queue_events()
+		case <-timer.C:
+			// the replies are still taken for a while, see
+			// query_expiry
+			return "", false
+		}
+	}
+}
+
+func cancel_query(kinds []query_kind) {
+	query_mu.Lock()
+	for _, kind := range kinds {
+		if query_pending[kind] > 0 {
+			query_pending[kind]--
+		}
+	}
+	query_mu.Unlock()
+}
+
+// Asks the terminal who it is and waits for the replies, see
+// IdentifyTerminal.
+func identify_terminal() (TerminalInfo, error) {
+	query_mu.Lock()
+	terminal_info = TerminalInfo{}
+	query_mu.Unlock()
+	_, ok := query(identify_queries, query_xtversion, query_da2, query_da1)
+	query_mu.Lock()
+	defer query_mu.Unlock()
+	if !ok {
+		return terminal_info, ErrNoReply
+	}
+	return terminal_info, nil
+}
+
+// Asks the terminal whether it supports the kitty keyboard protocol and waits
+// for the reply, DA1 goes last like in query_colors.
+func detect_kitty() bool {
+	query_mu.Lock()
+	kitty_supported = false
+	query_mu.Unlock()
+	query(ti_kitty_query+"\033[c", query_kitty, query_da1)
+	query_mu.Lock()
+	defer query_mu.Unlock()
+	return kitty_supported
+}
+
+// Asks the terminal for the size of a cell with the next flush, take_reply
+// takes the reply whenever it arrives. DA1 follows, so that the query doesn't
+// stay pending on terminals which don't support it.
+func request_cell_size() {
+	outbuf.WriteString(ti_cell_size)
+	outbuf.WriteString("\033[c")
+	queue_query(query_cell_size, query_da1)
+}
+
+// Whether update_cell_size found no cell size since the last call, and the
+// terminal should be asked for it.
+func cell_size_requested() bool {
+	query_mu.Lock()
+	defer query_mu.Unlock()
+	wanted := cell_size_wanted
+	cell_size_wanted = false
+	return wanted
+}
+
+// Fills the info in from a reply to one of the identification queries:
+//
+//	XTVERSION: DCS > | name(version) ST, or DCS > | name version ST
+//	DA2:       CSI > type ; firmware ; rom c
+//	DA1:       CSI ? level ; attributes... c
+func parse_identity(info *TerminalInfo, kind query_kind, seq string) {
+	switch kind {
+	case query_xtversion:
+		text := strings.TrimPrefix(seq, "\033P>|")
+		text = strings.TrimSuffix(strings.TrimSuffix(text, "\a"), "\033\\")
+		if i := strings.IndexByte(text, '('); i > 0 && strings.HasSuffix(text, ")") {
+			info.Name, info.Version = text[:i], text[i+1:len(text)-1]
+		} else if i := strings.IndexByte(text, ' '); i > 0 {
+			info.Name, info.Version = text[:i], strings.TrimSpace(text[i+1:])
+		} else {
+			info.Name = text
+		}
+	case query_da2:
+		params := parse_params(seq[3 : len(seq)-1])
+		if len(params) > 0 {
+			info.Type = params[0]
+		}
+		if len(params) > 1 {
+			info.Firmware = params[1]
+		}
+	case query_da1:
+		params := parse_params(seq[3 : len(seq)-1])
+		if len(params) == 0 {
+			return
+		}
+		// 61 to 65 are VT100 to VT500, the older VT100 family replies
+		// with the model instead
+		if params[0] > 60 && params[0] < 70 {
+			info.Level = params[0] - 60
+		} else {
+			info.Level = 1
+		}
+		info.Attributes = params[1:]
+	}
+}
+
+// Parses numeric params separated by ';', the missing ones are 0.
+func parse_params(s string) []int {
+	if s == "" {
+		return nil
+	}
+	fields := strings.Split(s, ";")
+	params := make([]int, len(fields))
+	for i, f := range fields {
+		params[i], _ = strconv.Atoi(f)
+	}
+	return params
+}
+
+// Asks the terminal for its default colors and palette and waits for the
+// replies, see QueryColors. DA1 goes last, so that terminals which don't
+// support the queries don't keep us waiting.
+func query_colors() (TerminalColors, error) {
+	seq := "\033]10;?\033\\\033]11;?\033\\"
+	kinds := []query_kind{query_color, query_color}
+	for i := 0; i < 16; i++ {
+		seq += "\033]4;" + strconv.Itoa(i) + ";?\033\\"
+		kinds = append(kinds, query_color)
+	}
+	seq += "\033[c"
+	kinds = append(kinds, query_da1)
+
+	query_mu.Lock()
+	terminal_colors = TerminalColors{}
+	query_mu.Unlock()
+	_, ok := query(seq, kinds...)
+	query_mu.Lock()
+	defer query_mu.Unlock()
+	if !ok {
+		return terminal_colors, ErrNoReply
+	}
+	return terminal_colors, nil
+}
+
+// Fills a color in from a reply to a color query:
+//
+//	OSC 10 ; color ST (foreground), OSC 11 ; color ST (background)
+//	OSC 4 ; index ; color ST (palette)
+//
+// The reply may end with BEL instead of ST. See parse_color_spec.
+func parse_color_reply(colors *TerminalColors, seq string) {
+	body := strings.TrimPrefix(seq, "\033]")
+	body = strings.TrimSuffix(strings.TrimSuffix(body, "\a"), "\033\\")
+	fields := strings.Split(body, ";")
+	switch {
+	case len(fields) == 2 && fields[0] == "10":
+		colors.Foreground = parse_color_spec(fields[1])
+	case len(fields) == 2 && fields[0] == "11":
+		colors.Background = parse_color_spec(fields[1])
+	case len(fields) == 3 && fields[0] == "4":
+		i, err := strconv.Atoi(fields[1])
+		if err == nil && i >= 0 && i < len(colors.Palette) {
+			colors.Palette[i] = parse_color_spec(fields[2])
+		}
+	}
+}
+
+// Parses an X11 color spec, rgb:r/g/b or #rgb, with 1 to 4 hex digits per
+// component. Returns ColorDefault if it isn't one.
+func parse_color_spec(spec string) Attribute {
+	var parts []string
+	switch {
+	case strings.HasPrefix(spec, "rgb:"):
+		parts = strings.Split(spec[4:], "/")
+	case strings.HasPrefix(spec, "#") && len(spec) > 1 && (len(spec)-1)%3 == 0:
+		n := (len(spec) - 1) / 3
+		parts = []string{spec[1 : 1+n], spec[1+n : 1+2*n], spec[1+2*n:]}
+	}
+	if len(parts) != 3 {
+		return ColorDefault
+	}
+	var rgb [3]uint8
+	for i, p := range parts {
+		if len(p) < 1 || len(p) > 4 {
+			return ColorDefault
+		}
+		v, err := strconv.ParseUint(p, 16, 16)
+		if err != nil {
+			return ColorDefault
+		}
+		// scale to 8 bits, e.g. ffff and f are both 255
+		max := uint64(1)<<(4*uint(len(p))) - 1
+		rgb[i] = uint8((v*255 + max/2) / max)
+	}
+	return RGBToAttribute(rgb[0], rgb[1], rgb[2])
+}
+
+// Extracts the events from inbuf into event_queue, taking the replies to the
+// queries along the way.
+func queue_events() {
+	var event Event
+	for extract_next_event(&event, true) == event_extracted {
+		event_queue = append(event_queue, event)
+	}
+}
+
+func reset_queries() {
+	query_mu.Lock()
+	query_pending = [query_kinds]int{}
+	query_expiry = [query_kinds]time.Time{}
+	queued_queries = nil
+	cell_size_wanted = false
+	query_mu.Unlock()
+	event_queue = nil
+}
@@ -0,0 +1,131 @@
+// +build !windows
+
+package termbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A recording is a header line and then one line per record: the time since
+// the start of the recording, and either "in" with the quoted input bytes or
+// "resize" with the new size of the terminal.
+//
+//	termbox-recording 1
+//	0s in "\x1b[A"
+//	1.25s resize 80 24
+const recording_header = "termbox-recording 1"
+
+type record struct {
+	at   time.Duration
+	gap  time.Duration // since the previous record
+	data []byte        // nil for a resize
+	w, h int
+}
+
+type recorder struct {
+	w     io.Writer
+	start time.Time
+	err   error
+}
+
+var (
+	// see StartRecording
+	recording *recorder
+
+	// see Replay, replay_comm is nil unless replaying
+	replay_comm     chan record
+	replay_stop     chan struct{}
+	replay_realtime bool
+)
+
+func (r *recorder) write(format string, args ...interface{}) {
+	if r.err != nil {
+		return
+	}
+	at := time.Since(r.start).Round(time.Microsecond)
+	_, r.err = fmt.Fprintf(r.w, "%v "+format+"\n", append([]interface{}{at}, args...)...)
+}
+
+func (r *recorder) input(data []byte) {
+	r.write("in %q", data)
+}
+
+func (r *recorder) resize(w, h int) {
+	r.write("resize %d %d", w, h)
+}
+
+func parse_recording(rd io.Reader) ([]record, error) {
+	sc := bufio.NewScanner(rd)
+	sc.Buffer(nil, 1<<20)
+	if !sc.Scan() || sc.Text() != recording_header {
+		if sc.Err() != nil {
+			return nil, sc.Err()
+		}
+		return nil, errors.New("termbox: not a termbox recording")
+	}
+
+	var records []record
+	var prev time.Duration
+	for line := 2; sc.Scan(); line++ {
+		fields := strings.SplitN(sc.Text(), " ", 3)
+		if len(fields) == 1 && fields[0] == "" {
+			continue
+		}
+		bad := fmt.Errorf("termbox: bad record on line %d of the recording", line)
+		if len(fields) != 3 {
+			return nil, bad
+		}
+		at, err := time.ParseDuration(fields[0])
+		if err != nil || at < prev {
+			return nil, bad
+		}
+		r := record{at: at, gap: at - prev}
+		prev = at
+		switch fields[1] {
+		case "in":
+			s, err := strconv.Unquote(fields[2])
+			if err != nil {
+				return nil, bad
+			}
+			r.data = []byte(s)
+		case "resize":
+			if _, err := fmt.Sscanf(fields[2], "%d %d", &r.w, &r.h); err != nil {
+				return nil, bad
+			}
+		default:
+			return nil, bad
+		}
+		records = append(records, r)
+	}
+	return records, sc.Err()
+}
+
+// Feeds the records to replay_comm, at the recorded times if realtime.
+func replay(records []record, realtime bool, comm chan<- record, stop <-chan struct{}) {
+	defer close(comm)
+	start := time.Now()
+	for _, r := range records {
+		if realtime {
+			if d := time.Until(start.Add(r.at)); d > 0 {
+				timer := time.NewTimer(d)
+				select {
+				case <-timer.C:
+				case <-stop:
+					timer.Stop()
+					return
+				}
+			}
+		}
+		select {
+		case comm <- r:
+		case <-stop:
+			return
+		}
+	}
+}
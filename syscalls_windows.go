@@ -33,6 +33,18 @@ const (
 	vk_f10         = 0x79
 	vk_f11         = 0x7a
 	vk_f12         = 0x7b
+	vk_f13         = 0x7c
+	vk_f14         = 0x7d
+	vk_f15         = 0x7e
+	vk_f16         = 0x7f
+	vk_f17         = 0x80
+	vk_f18         = 0x81
+	vk_f19         = 0x82
+	vk_f20         = 0x83
+	vk_f21         = 0x84
+	vk_f22         = 0x85
+	vk_f23         = 0x86
+	vk_f24         = 0x87
 	vk_insert      = 0x2d
 	vk_delete      = 0x2e
 	vk_home        = 0x24
@@ -48,6 +60,11 @@ const (
 	vk_enter       = 0xd
 	vk_esc         = 0x1b
 	vk_space       = 0x20
+	vk_clear       = 0xc
+	vk_pause       = 0x13
+	vk_select      = 0x29
+	vk_snapshot    = 0x2c
+	vk_apps        = 0x5d
 
 	left_alt_pressed   = 0x2
 	left_ctrl_pressed  = 0x8
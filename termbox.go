@@ -10,6 +10,9 @@ import "strings"
 import "strconv"
 import "os"
 import "io"
+import "time"
+import "fmt"
+import "encoding/base64"
 
 // private API
 
@@ -34,6 +37,8 @@ const (
 	t_reverse
 	t_enter_keypad
 	t_exit_keypad
+	t_enter_title
+	t_exit_title
 	t_enter_mouse
 	t_exit_mouse
 	t_max_funcs
@@ -44,11 +49,24 @@ const (
 	attr_invalid  = Attribute(0xFFFF)
 )
 
+var (
+	osc52_prefix = []byte("\033]52;")
+	paste_start  = []byte(ti_paste_start)
+	paste_end    = []byte(ti_paste_end)
+)
+
 type input_event struct {
 	data []byte
 	err  error
 }
 
+// A key sequence with modifiers, see terminfo.go#ti_mod_keys.
+type mod_key struct {
+	seq string
+	key Key
+	mod Modifier
+}
+
 type extract_event_res int
 
 const (
@@ -59,8 +77,13 @@ const (
 
 var (
 	// term specific sequences
-	keys  []string
-	funcs []string
+	keys     []string
+	funcs    []string
+	ti_ext   map[string]string
+	mod_keys []mod_key
+
+	// see RegisterKey
+	user_keys []mod_key
 
 	// termbox inner state
 	orig_tios      syscall_Termios
@@ -68,6 +91,8 @@ var (
 	front_buffer   cellbuf
 	termw          int
 	termh          int
+	cell_w         int // guarded by query_mu, as is cell_h
+	cell_h         int
 	input_mode     = InputEsc
 	output_mode    = OutputNormal
 	out            *os.File
@@ -77,6 +102,9 @@ var (
 	lastbg         = attr_invalid
 	lastx          = coord_invalid
 	lasty          = coord_invalid
+	lastlink       *Hyperlink
+	has_osc        bool
+	has_hyperlinks bool
 	cursor_x       = cursor_hidden
 	cursor_y       = cursor_hidden
 	foreground     = ColorDefault
@@ -90,6 +118,26 @@ var (
 	interrupt_comm = make(chan struct{})
 	intbuf         = make([]byte, 0, 16)
 
+	// things to undo on Close
+	cursor_style_changed bool
+	cursor_color_changed bool
+	title_pushed         bool
+
+	// how long to wait for the rest of a partially read escape sequence,
+	// see SetEscDelay
+	esc_delay = time.Duration(default_esc_delay)
+
+	// kitty keyboard protocol state, kitty_supported is guarded by
+	// query_mu, see detect_kitty
+	kitty_pushed    bool
+	kitty_queried   bool
+	kitty_supported bool
+
+	// see parse_paste
+	paste_scan      int
+	paste_continues bool
+	paste_limit     = 1 << 20
+
 	// grayscale indexes
 	grayscale = []Attribute{
 		0, 17, 233, 234, 235, 236, 237, 238, 239, 240, 241, 242, 243, 244,
@@ -199,6 +247,264 @@ func escapeRGB(fg bool, r uint8, g uint8, b uint8) string {
 	return escape
 }
 
+// The default xterm palette, for places where a palette color has to be turned
+// into an actual RGB value.
+var xterm_palette = [16][3]uint8{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+// Returns the 0-based 256 color palette index of a color, the same way
+// send_attr interprets it for the current output mode.
+func palette_index(a Attribute) int {
+	switch output_mode {
+	case Output216:
+		a &= 0xFF
+		if a > 216 {
+			a = 216
+		}
+		return int(a) + 0x10 - 1
+	case OutputGrayscale:
+		a &= 0x1F
+		if a > 26 {
+			a = 26
+		}
+		return int(grayscale[a]) - 1
+	case Output256:
+		return int(a&0x1FF) - 1
+	default:
+		return int(a&0xFF) - 1
+	}
+}
+
+// Converts a color to RGB. RGB attributes are taken as is, palette colors are
+// converted using the default xterm palette.
+func attribute_to_rgb(a Attribute) (uint8, uint8, uint8) {
+	if a >= max_attr {
+		return AttributeToRGB(a)
+	}
+	idx := palette_index(a)
+	switch {
+	case idx < 0:
+		return 0, 0, 0
+	case idx < 16:
+		c := xterm_palette[idx]
+		return c[0], c[1], c[2]
+	case idx < 232:
+		idx -= 16
+		level := func(n int) uint8 {
+			if n == 0 {
+				return 0
+			}
+			return uint8(55 + n*40)
+		}
+		return level(idx / 36), level(idx / 6 % 6), level(idx % 6)
+	case idx < 256:
+		v := uint8(8 + (idx-232)*10)
+		return v, v, v
+	}
+	return 0, 0, 0
+}
+
+// Formats a color the way OSC color sequences expect it.
+func rgb_spec(r, g, b uint8) string {
+	return fmt.Sprintf("rgb:%02x/%02x/%02x", r, g, b)
+}
+
+// Removes control characters, so that the string can be safely embedded into
+// an OSC sequence.
+func sanitize_osc(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r >= 0x7F && r < 0xA0 {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// Writes an OSC 0/1/2 sequence, saving the current title and icon name on
+// the terminal's stack first (XTWINOPS 22), so that Close can restore them.
+// The window title alone goes through the terminfo tsl/fsl capabilities when
+// the terminal has them.
+func write_title(ps int, s string) {
+	if has_osc && !title_pushed {
+		outbuf.WriteString("\033[22;0t")
+		title_pushed = true
+	}
+	s = sanitize_osc(s)
+	if tsl, ok := title_start(); ps == 2 && ok {
+		outbuf.WriteString(tsl)
+		outbuf.WriteString(s)
+		outbuf.WriteString(funcs[t_exit_title])
+		return
+	}
+	if !has_osc {
+		return
+	}
+	outbuf.WriteString("\033]")
+	outbuf.Write(strconv.AppendUint(intbuf, uint64(ps), 10))
+	outbuf.WriteString(";")
+	outbuf.WriteString(s)
+	outbuf.WriteString("\033\\")
+}
+
+// Returns the expanded terminfo tsl capability, which some entries
+// parametrize with the column on the status line. Returns false if the
+// terminal doesn't have it or it has parameters we don't understand.
+func title_start() (string, bool) {
+	tsl := funcs[t_enter_title]
+	if tsl == "" || funcs[t_exit_title] == "" {
+		return "", false
+	}
+	if strings.IndexByte(tsl, '%') == -1 {
+		return tsl, true
+	}
+	return ti_param1(tsl, 0)
+}
+
+func clipboard_sequence(selection Selection, data string) string {
+	return "\033]52;" + string(selection) + ";" + data + "\033\\"
+}
+
+// Writes a sequence which has to reach the outer terminal, wrapping it in a
+// DCS passthrough sequence when running inside tmux or screen.
+func write_passthrough(seq string) {
+	switch {
+	case os.Getenv("TMUX") != "":
+		// tmux wants all the ESCs inside to be doubled
+		outbuf.WriteString("\033Ptmux;")
+		outbuf.WriteString(strings.Replace(seq, "\033", "\033\033", -1))
+		outbuf.WriteString("\033\\")
+	case os.Getenv("STY") != "":
+		// screen has a limit on the length of the DCS string, so the
+		// sequence is split into chunks, each one wrapped separately
+		const chunk = 512
+		for len(seq) > 0 {
+			n := chunk
+			if n > len(seq) {
+				n = len(seq)
+			}
+			outbuf.WriteString("\033P")
+			outbuf.WriteString(seq[:n])
+			outbuf.WriteString("\033\\")
+			seq = seq[n:]
+		}
+	default:
+		outbuf.WriteString(seq)
+	}
+}
+
+// Parses a bracketed paste: ESC [ 200 ~ text ESC [ 201 ~. Returns 0 if the
+// paste is not complete yet. Pastes can be large and arrive in many small
+// pieces, so for the terminal input remember how far we've looked already to
+// avoid rescanning it over and over again, see drop_input. A paste longer than
+// paste_limit is split into several events, the rest of it follows without
+// the start marker, see paste_continues.
+func parse_paste(event *Event, buf []byte) int {
+	head := len(ti_paste_start)
+	if paste_continues && is_input(buf) {
+		head = 0
+	}
+	start := head
+	if is_input(buf) && paste_scan > start {
+		start = paste_scan
+	}
+	i := bytes.Index(buf[start:], paste_end)
+	if i == -1 {
+		if len(buf)-head < paste_limit || !is_input(buf) {
+			if is_input(buf) {
+				paste_scan = len(buf) - len(paste_end) + 1
+			}
+			return 0
+		}
+		// keep what may be the beginning of the end marker and don't
+		// split a rune
+		end := len(buf) - len(paste_end) + 1
+		for end > head && !utf8.RuneStart(buf[end]) {
+			end--
+		}
+		event.Type = EventPaste
+		event.Text = string(buf[head:end])
+		paste_continues = true
+		return end
+	}
+	end := start + i
+	event.Type = EventPaste
+	event.Text = string(buf[head:end])
+	if is_input(buf) {
+		paste_continues = false
+	}
+	return end + len(paste_end)
+}
+
+// Parses an OSC 52 reply: ESC ] 52 ; Pc ; Pd, terminated by BEL or ST.
+// Returns 0 if the reply is not complete yet.
+func parse_clipboard_reply(event *Event, buf []byte) int {
+	end, n := -1, 0
+	for i := len(osc52_prefix); i < len(buf); i++ {
+		if buf[i] == '\a' {
+			end, n = i, i+1
+			break
+		}
+		if buf[i] == '\033' && i+1 < len(buf) && buf[i+1] == '\\' {
+			end, n = i, i+2
+			break
+		}
+	}
+	if end == -1 {
+		return 0
+	}
+
+	body := string(buf[len(osc52_prefix):end])
+	event.Type = EventClipboard
+	event.Selection = SelectionClipboard
+	if semi := strings.IndexByte(body, ';'); semi != -1 {
+		if semi > 0 {
+			event.Selection = Selection(body[0])
+		}
+		body = body[semi+1:]
+	}
+	data, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		event.Err = err
+	}
+	event.Text = string(data)
+	return n
+}
+
+// How long PollEvent waits for the rest of the partially read sequence in buf:
+// the Esc delay, or longer while a reply is expected, see query_deadline. A
+// bare ESC is most likely the Esc key, so it isn't held any longer.
+func esc_wait_delay(buf []byte) time.Duration {
+	d := esc_delay
+	if len(buf) < 2 {
+		return d
+	}
+	if deadline := query_deadline(); !deadline.IsZero() {
+		if until := time.Until(deadline); until > d {
+			d = until
+		}
+	}
+	return d
+}
+
+// Brings back the cursor style and color changed by SetCursorStyle and
+// SetCursorColor, see Close.
+func restore_cursor() {
+	if cursor_style_changed {
+		if se := ti_ext["Se"]; se != "" {
+			out.WriteString(se)
+		} else if seq, ok := ti_param1(ti_ext["Ss"], int(CursorDefault)); ok {
+			out.WriteString(seq)
+		}
+	}
+	if cursor_color_changed && has_osc {
+		out.WriteString("\033]112\033\\")
+	}
+}
+
 type winsize struct {
 	rows    uint16
 	cols    uint16
@@ -213,6 +519,46 @@ func get_term_size(fd uintptr) (int, int) {
 	return int(sz.cols), int(sz.rows)
 }
 
+// Updates the size of a cell in pixels. Many terminals fill the pixel size of
+// the window in, for the others it is asked for with CSI 16t by the next Flush
+// if Pixels input mode needs it, see request_cell_size. PollEvent calls it on
+// SIGWINCH, so it doesn't write the query itself.
+func update_cell_size() {
+	var sz winsize
+	_, _, _ = syscall.Syscall(syscall.SYS_IOCTL,
+		outfd, uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&sz)))
+	query_mu.Lock()
+	defer query_mu.Unlock()
+	if sz.xpixels != 0 && sz.ypixels != 0 && sz.cols != 0 && sz.rows != 0 {
+		cell_w = int(sz.xpixels / sz.cols)
+		cell_h = int(sz.ypixels / sz.rows)
+	} else if input_mode&InputPixels != 0 {
+		cell_size_wanted = true
+	}
+}
+
+// Parses the reply to CSI 16t, a complete escape sequence:
+// CSI 6 ; height ; width t.
+func parse_cell_size_report(buf []byte) (w, h int, ok bool) {
+	s := string(buf)
+	if !strings.HasPrefix(s, "\033[6;") || !strings.HasSuffix(s, "t") {
+		return 0, 0, false
+	}
+	f := strings.Split(s[4:len(s)-1], ";")
+	if len(f) != 2 {
+		return 0, 0, false
+	}
+	h, err := strconv.Atoi(f[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	w, err = strconv.Atoi(f[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return w, h, true
+}
+
 func send_attr(fg, bg Attribute) {
 	if fg == lastfg && bg == lastbg {
 		return
@@ -302,6 +648,54 @@ func send_attr(fg, bg Attribute) {
 	lastfg, lastbg = fg, bg
 }
 
+// Opens or closes an OSC 8 hyperlink if it differs from the current one.
+func send_link(link *Hyperlink) {
+	if link == lastlink || !has_hyperlinks {
+		return
+	}
+	if lastlink != nil {
+		outbuf.WriteString("\033]8;;\033\\")
+	}
+	if link != nil {
+		outbuf.WriteString("\033]8;")
+		if valid_link_id(link.ID) {
+			outbuf.WriteString("id=")
+			outbuf.WriteString(link.ID)
+		}
+		outbuf.WriteString(";")
+		write_link_uri(link.URI)
+		outbuf.WriteString("\033\\")
+	}
+	lastlink = link
+}
+
+// Whether the ID can go into an OSC 8 sequence as is: it must not end the
+// sequence or the params early.
+func valid_link_id(id string) bool {
+	if id == "" {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x20 || id[i] > 0x7E || id[i] == ';' || id[i] == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// Writes the URI of an OSC 8 sequence, percent-encoding the bytes outside of
+// printable ASCII, so that it can't end the sequence early.
+func write_link_uri(uri string) {
+	const hex = "0123456789ABCDEF"
+	for i := 0; i < len(uri); i++ {
+		if c := uri[i]; c < 0x20 || c > 0x7E {
+			outbuf.Write([]byte{'%', hex[c>>4], hex[c&0xF]})
+		} else {
+			outbuf.WriteByte(c)
+		}
+	}
+}
+
 func send_char(x, y int, ch rune) {
 	var buf [8]byte
 	n := utf8.EncodeRune(buf[:], ch)
@@ -313,6 +707,7 @@ func send_char(x, y int, ch rune) {
 }
 
 func flush() error {
+	send_queued_queries()
 	_, err := io.Copy(out, &outbuf)
 	outbuf.Reset()
 	return err
@@ -348,6 +743,54 @@ func update_size_maybe() error {
 	return nil
 }
 
+// Parses ESC [ row ; col R, the coordinates are 1-based.
+func parse_cursor_report(buf []byte) (row, col int, ok bool) {
+	s := string(buf)
+	if !strings.HasPrefix(s, "\033[") || !strings.HasSuffix(s, "R") {
+		return 0, 0, false
+	}
+	semi := strings.IndexByte(s, ';')
+	if semi == -1 {
+		return 0, 0, false
+	}
+	r, err := strconv.Atoi(s[2:semi])
+	if err != nil {
+		return 0, 0, false
+	}
+	c, err := strconv.Atoi(s[semi+1 : len(s)-1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return r, c, true
+}
+
+// Prints a few characters with disputed widths in the top left corner of the
+// alternate screen and asks where the cursor ends up, see query. Init calls
+// it once the input is being read, and the characters are concealed (SGR 8),
+// so nothing shows up on the screen.
+func probe_widths() {
+	measure := func(s string) int {
+		reply, ok := query("\033[H\033[8m"+s+"\033[m\033[6n", query_cursor)
+		if !ok {
+			return -1
+		}
+		_, col, _ := parse_cursor_report([]byte(reply))
+		return col - 1
+	}
+
+	// U+2460 (circled digit one) is East Asian ambiguous, U+1F600 has the
+	// emoji presentation
+	w := measure("\u2460")
+	if w < 1 {
+		// the terminal doesn't answer, don't waste any more time
+		return
+	}
+	width_policy.Ambiguous = w
+	if w = measure("\U0001F600"); w > 0 {
+		width_policy.Emoji = w
+	}
+}
+
 func tcsetattr(fd uintptr, termios *syscall_Termios) error {
 	r, _, e := syscall.Syscall(syscall.SYS_IOCTL,
 		fd, uintptr(syscall_TCSETS), uintptr(unsafe.Pointer(termios)))
@@ -366,35 +809,64 @@ func tcgetattr(fd uintptr, termios *syscall_Termios) error {
 	return nil
 }
 
+// Decodes the button code of a mouse report. The low two bits together with
+// bits 64 and 128 select the button, bits 4, 8 and 16 are Shift, Alt and Ctrl,
+// and bit 32 is motion.
+func decode_mouse_button(event *Event, b int) bool {
+	switch n := b &^ (4 | 8 | 16 | 32); n {
+	case 0:
+		event.Key = MouseLeft
+	case 1:
+		event.Key = MouseMiddle
+	case 2:
+		event.Key = MouseRight
+	case 3:
+		if b&32 != 0 {
+			// any-event tracking reports the motion with no button as a
+			// motion with the release "button"
+			event.Key = MouseHover
+		} else {
+			event.Key = MouseRelease
+		}
+	case 64:
+		event.Key = MouseWheelUp
+	case 65:
+		event.Key = MouseWheelDown
+	case 66:
+		event.Key = MouseWheelLeft
+	case 67:
+		event.Key = MouseWheelRight
+	case 128, 129, 130, 131:
+		event.Key = MouseButton4 - Key(n-128)
+	case 192:
+		// the next group of four, xterm stops at 131
+		event.Key = MouseButton8
+	default:
+		return false
+	}
+	if b&4 != 0 {
+		event.Mod |= ModShift
+	}
+	if b&8 != 0 {
+		event.Mod |= ModAlt
+	}
+	if b&16 != 0 {
+		event.Mod |= ModCtrl
+	}
+	if b&32 != 0 {
+		event.Mod |= ModMotion
+	}
+	return true
+}
+
 func parse_mouse_event(event *Event, buf string) (int, bool) {
 	if strings.HasPrefix(buf, "\033[M") && len(buf) >= 6 {
 		// X10 mouse encoding, the simplest one
 		// \033 [ M Cb Cx Cy
-		b := buf[3] - 32
-		switch b & 3 {
-		case 0:
-			if b&64 != 0 {
-				event.Key = MouseWheelUp
-			} else {
-				event.Key = MouseLeft
-			}
-		case 1:
-			if b&64 != 0 {
-				event.Key = MouseWheelDown
-			} else {
-				event.Key = MouseMiddle
-			}
-		case 2:
-			event.Key = MouseRight
-		case 3:
-			event.Key = MouseRelease
-		default:
+		if !decode_mouse_button(event, int(buf[3])-32) {
 			return 6, false
 		}
 		event.Type = EventMouse // KeyEvent by default
-		if b&32 != 0 {
-			event.Mod |= ModMotion
-		}
 
 		// the coord is 1,1 for upper left
 		event.MouseX = int(buf[4]) - 1 - 32
@@ -451,34 +923,28 @@ func parse_mouse_event(event *Event, buf string) (int, bool) {
 		if isU {
 			n1 -= 32
 		}
-		switch n1 & 3 {
-		case 0:
-			if n1&64 != 0 {
-				event.Key = MouseWheelUp
-			} else {
-				event.Key = MouseLeft
-			}
-		case 1:
-			if n1&64 != 0 {
-				event.Key = MouseWheelDown
-			} else {
-				event.Key = MouseMiddle
-			}
-		case 2:
-			event.Key = MouseRight
-		case 3:
-			event.Key = MouseRelease
-		default:
+		if !decode_mouse_button(event, int(n1)) {
 			return mi + 1, false
 		}
 		if !isM {
-			// on xterm mouse release is signaled by lowercase m
+			// on xterm mouse release is signaled by lowercase m, which
+			// also tells the button, if it is a button at all
+			if k := event.Key; k >= MouseRight || k <= MouseButton4 && k >= MouseButton8 {
+				event.Button = k
+			}
 			event.Key = MouseRelease
 		}
 
 		event.Type = EventMouse // KeyEvent by default
-		if n1&32 != 0 {
-			event.Mod |= ModMotion
+		if !isU && input_mode&InputPixels != 0 {
+			// SGR-Pixels reports the position in pixels
+			event.PixelX = int(n2) - 1
+			event.PixelY = int(n3) - 1
+			if w, h := CellSize(); w != 0 && h != 0 {
+				event.MouseX = event.PixelX / w
+				event.MouseY = event.PixelY / h
+			}
+			return mi + 1, true
 		}
 
 		event.MouseX = int(n2) - 1
@@ -489,18 +955,482 @@ func parse_mouse_event(event *Event, buf string) (int, bool) {
 	return 0, false
 }
 
-func parse_escape_sequence(event *Event, buf []byte) (int, bool) {
-	bufstr := string(buf)
+// Converts the xterm modifier parameter (1 + bitmask of Shift, Alt, Ctrl and
+// Meta) to termbox modifiers.
+func xterm_modifier(param int) Modifier {
+	var mod Modifier
+	bits := param - 1
+	if bits&1 != 0 {
+		mod |= ModShift
+	}
+	if bits&2 != 0 {
+		mod |= ModAlt
+	}
+	if bits&4 != 0 {
+		mod |= ModCtrl
+	}
+	if bits&8 != 0 {
+		mod |= ModMeta
+	}
+	return mod
+}
+
+// Converts the kitty keyboard protocol modifier parameter (1 + bitmask of
+// Shift, Alt, Ctrl, Super, Hyper, Meta, Caps Lock and Num Lock) to termbox
+// modifiers.
+func kitty_modifier(param int) Modifier {
+	var mod Modifier
+	bits := param - 1
+	if bits&1 != 0 {
+		mod |= ModShift
+	}
+	if bits&2 != 0 {
+		mod |= ModAlt
+	}
+	if bits&4 != 0 {
+		mod |= ModCtrl
+	}
+	if bits&8 != 0 {
+		mod |= ModSuper
+	}
+	if bits&16 != 0 {
+		mod |= ModHyper
+	}
+	if bits&32 != 0 {
+		mod |= ModMeta
+	}
+	return mod
+}
+
+// Parses "mods[:action]", the modifier parameter of key sequences, the
+// action is only sent by the kitty keyboard protocol.
+func parse_modifier_param(param string) (Modifier, KeyAction, bool) {
+	m, action := param, ""
+	if colon := strings.IndexByte(param, ':'); colon != -1 {
+		m, action = param[:colon], param[colon+1:]
+	}
+	n := 1
+	if m != "" {
+		var err error
+		n, err = strconv.Atoi(m)
+		if err != nil || n < 1 || n > 256 {
+			return 0, KeyPress, false
+		}
+	}
+	var act KeyAction
+	switch action {
+	case "", "1":
+		act = KeyPress
+	case "2":
+		act = KeyRepeat
+	case "3":
+		act = KeyRelease
+	default:
+		return 0, KeyPress, false
+	}
+	if kitty_pushed {
+		return kitty_modifier(n), act, true
+	}
+	return xterm_modifier(n), act, true
+}
+
+// Converts a key code of the kitty keyboard protocol to a termbox key. Codes
+// of the keys which have no text are from the Unicode Private Use Area.
+// Returns false for the keys termbox doesn't know about.
+func kitty_key(code rune) (Key, rune, bool) {
+	switch code {
+	case 27:
+		return KeyEsc, 0, true
+	case 13:
+		return KeyEnter, 0, true
+	case 9:
+		return KeyTab, 0, true
+	case 8:
+		return KeyBackspace, 0, true
+	case 127:
+		return KeyBackspace2, 0, true
+	case 32:
+		return KeySpace, 0, true
+	}
+
+	// keypad keys
+	if code >= 57399 && code <= 57408 {
+		return 0, '0' + code - 57399, true
+	}
+	switch code {
+	case 57409:
+		return 0, '.', true
+	case 57410:
+		return 0, '/', true
+	case 57411:
+		return 0, '*', true
+	case 57412:
+		return 0, '-', true
+	case 57413:
+		return 0, '+', true
+	case 57414:
+		return KeyEnter, 0, true
+	case 57415:
+		return 0, '=', true
+	case 57416:
+		return 0, ',', true
+	case 57417:
+		return KeyArrowLeft, 0, true
+	case 57418:
+		return KeyArrowRight, 0, true
+	case 57419:
+		return KeyArrowUp, 0, true
+	case 57420:
+		return KeyArrowDown, 0, true
+	case 57421:
+		return KeyPgup, 0, true
+	case 57422:
+		return KeyPgdn, 0, true
+	case 57423:
+		return KeyHome, 0, true
+	case 57424:
+		return KeyEnd, 0, true
+	case 57425:
+		return KeyInsert, 0, true
+	case 57426:
+		return KeyDelete, 0, true
+	case 57427:
+		return KeyBegin, 0, true
+	}
+
+	// function and system keys
+	if code >= 57376 && code <= 57387 {
+		return KeyF13 - Key(code-57376), 0, true
+	}
+	switch code {
+	case 57361:
+		return KeyPrint, 0, true
+	case 57362:
+		return KeyPause, 0, true
+	case 57363:
+		return KeyMenu, 0, true
+	}
+
+	// lock and modifier keys, media keys, etc.
+	if code >= 57344 && code <= 63743 {
+		return 0, 0, false
+	}
+	if code < ' ' || !utf8.ValidRune(code) {
+		return 0, 0, false
+	}
+	return 0, code, true
+}
+
+// Parses CSI u key reports of the kitty keyboard protocol:
+// CSI code[:shifted[:base]] [; mods[:action] [; text]] u
+// and the replies to the protocol query, CSI ? flags u, which arrive late. The simple form,
+// CSI code ; mods u, is also used by xterm's modifyOtherKeys, as well as
+// CSI 27 ; mods ; code ~.
+func parse_csi_u(event *Event, buf string) (int, bool) {
+	if !strings.HasPrefix(buf, "\033[") {
+		return 0, false
+	}
+	i := 2
+	reply := i < len(buf) && buf[i] == '?'
+	if reply {
+		i++
+	}
+	for i < len(buf) && (buf[i] >= '0' && buf[i] <= '9' || buf[i] == ';' || buf[i] == ':') {
+		i++
+	}
+	if i == len(buf) || buf[i] != 'u' && buf[i] != '~' || i == 2 {
+		return 0, false
+	}
+	if reply {
+		if buf[i] != 'u' {
+			return 0, false
+		}
+		return i + 1, false
+	}
+
+	fields := strings.Split(buf[2:i], ";")
+	if buf[i] == '~' {
+		if len(fields) != 3 || fields[0] != "27" {
+			return 0, false
+		}
+		fields = []string{fields[2], fields[1]}
+	}
+	codes := strings.Split(fields[0], ":")
+	atoi := func(s string) rune {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > utf8.MaxRune {
+			return 0
+		}
+		return rune(n)
+	}
+
+	code := atoi(codes[0])
+	mod, action := Modifier(0), KeyPress
+	if len(fields) > 1 {
+		var ok bool
+		mod, action, ok = parse_modifier_param(fields[1])
+		if !ok {
+			return i + 1, false
+		}
+	}
+	key, ch, ok := kitty_key(code)
+	if !ok {
+		// still a valid sequence, just nothing we can report
+		return i + 1, false
+	}
+
+	event.Action = action
+	if len(codes) > 1 {
+		event.ShiftedCh = atoi(codes[1])
+	}
+	if len(codes) > 2 {
+		event.BaseCh = atoi(codes[2])
+	}
+	if len(fields) > 2 {
+		var text []rune
+		for _, cp := range strings.Split(fields[2], ":") {
+			if r := atoi(cp); r != 0 {
+				text = append(text, r)
+			}
+		}
+		event.Text = string(text)
+	}
+
+	if ch != 0 && mod&ModCtrl != 0 {
+		// for non-latin layouts use the key from the base layout
+		if k, ok := CtrlKey(ch); ok {
+			key, ch = k, 0
+		} else if k, ok := CtrlKey(event.BaseCh); ok {
+			key, ch = k, 0
+		}
+	} else if key == KeySpace && mod&ModCtrl != 0 {
+		key = KeyCtrlSpace
+	}
+	if ch != 0 {
+		switch {
+		case event.Text != "":
+			ch, _ = utf8.DecodeRuneInString(event.Text)
+		case mod&ModShift != 0 && event.ShiftedCh != 0:
+			ch = event.ShiftedCh
+		}
+	}
+
+	event.Ch = ch
+	event.Key = key
+	event.Mod |= mod
+	return i + 1, true
+}
+
+// Looks up the unmodified form of a key sequence in the terminfo keys, and if
+// it isn't there, in the sequences xterm uses.
+func lookup_key(seq string) (Key, bool) {
 	for i, key := range keys {
-		if strings.HasPrefix(bufstr, key) {
-			event.Ch = 0
-			event.Key = Key(0xFFFF - i)
-			return len(key), true
+		if key == seq {
+			return ti_key(i), true
+		}
+	}
+
+	switch seq {
+	case "\033[A", "\033OA":
+		return KeyArrowUp, true
+	case "\033[B", "\033OB":
+		return KeyArrowDown, true
+	case "\033[C", "\033OC":
+		return KeyArrowRight, true
+	case "\033[D", "\033OD":
+		return KeyArrowLeft, true
+	case "\033[H", "\033OH", "\033[1~", "\033[7~":
+		return KeyHome, true
+	case "\033[F", "\033OF", "\033[4~", "\033[8~":
+		return KeyEnd, true
+	case "\033[2~":
+		return KeyInsert, true
+	case "\033[3~":
+		return KeyDelete, true
+	case "\033[5~":
+		return KeyPgup, true
+	case "\033[6~":
+		return KeyPgdn, true
+	case "\033[P", "\033OP", "\033[11~":
+		return KeyF1, true
+	case "\033[Q", "\033OQ", "\033[12~":
+		return KeyF2, true
+	case "\033[R", "\033OR", "\033[13~":
+		return KeyF3, true
+	case "\033[S", "\033OS", "\033[14~":
+		return KeyF4, true
+	case "\033[15~":
+		return KeyF5, true
+	case "\033[17~":
+		return KeyF6, true
+	case "\033[18~":
+		return KeyF7, true
+	case "\033[19~":
+		return KeyF8, true
+	case "\033[20~":
+		return KeyF9, true
+	case "\033[21~":
+		return KeyF10, true
+	case "\033[23~":
+		return KeyF11, true
+	case "\033[24~":
+		return KeyF12, true
+	case "\033[25~":
+		return KeyF13, true
+	case "\033[26~":
+		return KeyF14, true
+	case "\033[28~":
+		return KeyF15, true
+	case "\033[29~":
+		return KeyF16, true
+	case "\033[31~":
+		return KeyF17, true
+	case "\033[32~":
+		return KeyF18, true
+	case "\033[33~":
+		return KeyF19, true
+	case "\033[34~":
+		return KeyF20, true
+	case "\033[Z":
+		return KeyBacktab, true
+	case "\033OM":
+		return KeyKeypadEnter, true
+	case "\033[E", "\033OE":
+		return KeyBegin, true
+	}
+	return 0, false
+}
+
+// Parses keys with modifiers: the xterm forms CSI 1 ; mod X, CSI n ; mod ~,
+// SS3 mod X and SS3 1 ; mod X, and the rxvt forms CSI n $, CSI n ^, CSI n @
+// (Shift, Ctrl, Ctrl+Shift), CSI a-d (Shift+arrows) and SS3 a-d
+// (Ctrl+arrows).
+func parse_modified_key(event *Event, buf string) (int, bool) {
+	if !strings.HasPrefix(buf, "\033[") && !strings.HasPrefix(buf, "\033O") {
+		return 0, false
+	}
+	ss3 := buf[1] == 'O'
+
+	i := 2
+	for i < len(buf) && (buf[i] >= '0' && buf[i] <= '9' || buf[i] == ';' || buf[i] == ':') {
+		i++
+	}
+	if i == len(buf) {
+		return 0, false
+	}
+	final := buf[i]
+	params := strings.Split(buf[2:i], ";")
+	if buf[2:i] == "" {
+		params = nil
+	}
+
+	var base string
+	var mod Modifier
+	action := KeyPress
+	switch {
+	case len(params) == 0 && final >= 'A' && final <= 'Z':
+		// unmodified keys in the form not known to terminfo, e.g. kitty
+		// sends CSI P for F1
+		base = buf[:3]
+	case len(params) == 0 && final >= 'a' && final <= 'd':
+		// rxvt arrows
+		if ss3 {
+			mod = ModCtrl
+		} else {
+			mod = ModShift
+		}
+		base = buf[:2] + string(final-'a'+'A')
+	case len(params) == 1 && !ss3 && final == '~':
+		// unmodified keys in the form not known to terminfo
+		base = buf[:i+1]
+	case len(params) == 1 && !ss3 && (final == '$' || final == '^' || final == '@'):
+		// rxvt navigation and function keys
+		switch final {
+		case '$':
+			mod = ModShift
+		case '^':
+			mod = ModCtrl
+		case '@':
+			mod = ModCtrl | ModShift
+		}
+		base = "\033[" + params[0] + "~"
+	case len(params) == 1 && ss3 || len(params) == 2:
+		var ok bool
+		mod, action, ok = parse_modifier_param(params[len(params)-1])
+		if !ok {
+			return 0, false
+		}
+		if final == '~' && !ss3 {
+			base = "\033[" + params[0] + "~"
+		} else if final >= 'A' && final <= 'Z' && (len(params) == 1 || params[0] == "1") {
+			base = "\033[" + string(final)
+		} else {
+			return 0, false
 		}
+	default:
+		return 0, false
+	}
+
+	key, ok := lookup_key(base)
+	if !ok && len(params) > 0 && base[1] == '[' && final >= 'A' && final <= 'Z' {
+		// keypad mode variants
+		key, ok = lookup_key("\033O" + base[2:])
+	}
+	if !ok {
+		return 0, false
+	}
+	event.Ch = 0
+	event.Key = key
+	event.Mod |= mod
+	event.Action = action
+	return i + 1, true
+}
+
+func parse_escape_sequence(event *Event, buf []byte) (int, bool) {
+	n, key, mod, res := match_key_seq(buf)
+	if (res == match_key || res == match_sequence) && take_reply(buf[:n]) {
+		return n, false
+	}
+	switch res {
+	case match_key:
+		event.Ch = 0
+		event.Key = key
+		event.Mod |= mod
+		return n, true
+	case match_sequence:
+		// the parsers below only ever see the one sequence
+		buf = buf[:n]
+	default:
+		return 0, false
+	}
+
+	bufstr := string(buf)
+	if n, ok := parse_modified_key(event, bufstr); ok {
+		return n, true
+	}
+	if n, ok := parse_csi_u(event, bufstr); n != 0 {
+		return n, ok
+	}
+
+	// focus reports look a lot like mouse sequences, so check them first
+	if strings.HasPrefix(bufstr, ti_focus_in) || strings.HasPrefix(bufstr, ti_focus_out) {
+		event.Type = EventFocus
+		event.Focused = bufstr[2] == 'I'
+		return len(ti_focus_in), true
 	}
 
 	// if none of the keys match, let's try mouse sequences
-	return parse_mouse_event(event, bufstr)
+	if n, ok := parse_mouse_event(event, bufstr); n != 0 {
+		return n, ok
+	}
+
+	// a well-formed sequence termbox doesn't know about
+	event.Type = EventUnknown
+	event.Key = 0
+	event.Ch = 0
+	event.Mod = 0
+	event.Text = bufstr
+	return len(bufstr), true
 }
 
 func extract_raw_event(data []byte, event *Event) bool {
@@ -514,21 +1444,112 @@ func extract_raw_event(data []byte, event *Event) bool {
 	}
 
 	n = copy(data, inbuf)
-	copy(inbuf, inbuf[n:])
-	inbuf = inbuf[:len(inbuf)-n]
+	drop_input(n)
 
 	event.N = n
 	event.Type = EventRaw
 	return true
 }
 
+// Whether the buffer is the terminal input, inbuf, rather than something
+// passed to ParseEvent.
+func is_input(buf []byte) bool {
+	return len(buf) != 0 && len(inbuf) != 0 && &buf[0] == &inbuf[0]
+}
+
+// Removes the first n bytes of inbuf.
+func drop_input(n int) {
+	copy(inbuf, inbuf[n:])
+	inbuf = inbuf[:len(inbuf)-n]
+	paste_scan = 0
+}
+
+func is_partial_sequence(buf []byte) bool {
+	_, _, _, res := match_key_seq(buf)
+	return res == match_partial
+}
+
+// Extracts the next event from inbuf and removes its bytes from there. Skips
+// over sequences which are consumed without producing an event, like replies
+// to queries.
+func extract_next_event(event *Event, allow_esc_wait bool) extract_event_res {
+	for {
+		*event = Event{Type: EventKey}
+		status := extract_event(inbuf, event, allow_esc_wait)
+		if event.N != 0 {
+			drop_input(event.N)
+		}
+		if status == event_extracted {
+			coalesce_motion(event)
+			if event.Type == EventClipboard {
+				reply_arrived(query_clipboard)
+			}
+		}
+		if status != event_not_extracted || event.N == 0 {
+			return status
+		}
+	}
+}
+
+// Drops a mouse motion event if the input buffer already has another one of
+// the same kind waiting, so that bursts of motion don't flood the consumer.
+func coalesce_motion(event *Event) {
+	for event.Type == EventMouse && event.Mod&ModMotion != 0 {
+		if !bytes.HasPrefix(inbuf, []byte("\033[<")) && !bytes.HasPrefix(inbuf, []byte("\033[M")) {
+			return
+		}
+		next := Event{Type: EventKey}
+		n, ok := parse_mouse_event(&next, string(inbuf))
+		if !ok || next.Key != event.Key || next.Mod != event.Mod {
+			return
+		}
+		drop_input(n)
+		next.N = n
+		*event = next
+	}
+}
+
 func extract_event(inbuf []byte, event *Event, allow_esc_wait bool) extract_event_res {
 	if len(inbuf) == 0 {
 		event.N = 0
 		return event_not_extracted
 	}
 
+	// the rest of a long paste, see parse_paste
+	if paste_continues && is_input(inbuf) {
+		event.N = parse_paste(event, inbuf)
+		if event.N != 0 {
+			return event_extracted
+		}
+		return event_not_extracted
+	}
+
 	if inbuf[0] == '\033' {
+		// possible clipboard contents, the reply may be quite long, so
+		// wait for all of it if we asked for it, see esc_wait_delay
+		if bytes.HasPrefix(inbuf, osc52_prefix) {
+			if n := parse_clipboard_reply(event, inbuf); n != 0 {
+				event.N = n
+				return event_extracted
+			}
+			if allow_esc_wait && reply_pending(query_clipboard) {
+				event.N = 0
+				return esc_wait
+			}
+		} else if allow_esc_wait && bytes.HasPrefix(osc52_prefix, inbuf) && reply_pending(query_clipboard) {
+			event.N = 0
+			return esc_wait
+		}
+
+		// pasted text, wait for the end of it
+		if bytes.HasPrefix(inbuf, paste_start) {
+			event.N = parse_paste(event, inbuf)
+			if event.N != 0 {
+				return event_extracted
+			}
+			return event_not_extracted
+		}
+
 		// possible escape sequence
 		if n, ok := parse_escape_sequence(event, inbuf); n != 0 {
 			event.N = n
@@ -539,8 +1560,15 @@ func extract_event(inbuf []byte, event *Event, allow_esc_wait bool) extract_even
 			}
 		}
 
+		// possibly a partially read reply to a query, wait for the rest,
+		// see esc_wait_delay
+		if allow_esc_wait && is_partial_sequence(inbuf) && reply_prefix(inbuf) {
+			event.N = 0
+			return esc_wait
+		}
+
 		// possible partially read escape sequence; trigger a wait if appropriate
-		if enable_wait_for_escape_sequence() && allow_esc_wait {
+		if esc_delay > 0 && allow_esc_wait && is_partial_sequence(inbuf) {
 			event.N = 0
 			return esc_wait
 		}
@@ -572,6 +1600,15 @@ func extract_event(inbuf []byte, event *Event, allow_esc_wait bool) extract_even
 	// if we're here, this is not an escape sequence and not an alt sequence
 	// so, it's a FUNCTIONAL KEY or a UNICODE character
 
+	// keys registered with RegisterKey may start with anything
+	if n, key, mod, ok := match_user_key(inbuf); ok {
+		event.Ch = 0
+		event.Key = key
+		event.Mod |= mod
+		event.N = n
+		return event_extracted
+	}
+
 	// first of all check if it's a functional key
 	if Key(inbuf[0]) <= KeySpace || Key(inbuf[0]) == KeyBackspace2 {
 		// fill event, pop buffer, return success
@@ -1,17 +1,21 @@
 package termbox
 
+import "github.com/mattn/go-runewidth"
+
 // private API, common OS agnostic part
 
 type cellbuf struct {
 	width  int
 	height int
 	cells  []Cell
+	links  []*Hyperlink // see SetLink
 }
 
 func (this *cellbuf) init(width, height int) {
 	this.width = width
 	this.height = height
 	this.cells = make([]Cell, width*height)
+	this.links = make([]*Hyperlink, width*height)
 }
 
 func (this *cellbuf) resize(width, height int) {
@@ -22,6 +26,7 @@ func (this *cellbuf) resize(width, height int) {
 	oldw := this.width
 	oldh := this.height
 	oldcells := this.cells
+	oldlinks := this.links
 
 	this.init(width, height)
 	this.clear()
@@ -40,6 +45,7 @@ func (this *cellbuf) resize(width, height int) {
 		src := oldcells[srco : srco+minw]
 		dst := this.cells[dsto : dsto+minw]
 		copy(dst, src)
+		copy(this.links[dsto:dsto+minw], oldlinks[srco:srco+minw])
 	}
 }
 
@@ -49,6 +55,7 @@ func (this *cellbuf) clear() {
 		c.Ch = ' '
 		c.Fg = foreground
 		c.Bg = background
+		this.links[i] = nil
 	}
 }
 
@@ -57,3 +64,77 @@ const cursor_hidden = -1
 func is_cursor_hidden(x, y int) bool {
 	return x == cursor_hidden || y == cursor_hidden
 }
+
+var width_policy WidthPolicy
+
+// Characters which are displayed as emoji by default (Emoji_Presentation=Yes),
+// roughly. Ranges are sorted.
+var emoji_presentation = [][2]rune{
+	{0x231A, 0x231B}, {0x23E9, 0x23EC}, {0x23F0, 0x23F0}, {0x23F3, 0x23F3},
+	{0x25FD, 0x25FE}, {0x2614, 0x2615}, {0x2648, 0x2653}, {0x267F, 0x267F},
+	{0x2693, 0x2693}, {0x26A1, 0x26A1}, {0x26AA, 0x26AB}, {0x26BD, 0x26BE},
+	{0x26C4, 0x26C5}, {0x26CE, 0x26CE}, {0x26D4, 0x26D4}, {0x26EA, 0x26EA},
+	{0x26F2, 0x26F3}, {0x26F5, 0x26F5}, {0x26FA, 0x26FA}, {0x26FD, 0x26FD},
+	{0x2705, 0x2705}, {0x270A, 0x270B}, {0x2728, 0x2728}, {0x274C, 0x274C},
+	{0x274E, 0x274E}, {0x2753, 0x2755}, {0x2757, 0x2757}, {0x2795, 0x2797},
+	{0x27B0, 0x27B0}, {0x27BF, 0x27BF}, {0x2B1B, 0x2B1C}, {0x2B50, 0x2B50},
+	{0x2B55, 0x2B55}, {0x1F004, 0x1F004}, {0x1F0CF, 0x1F0CF},
+	{0x1F18E, 0x1F18E}, {0x1F191, 0x1F19A}, {0x1F1E6, 0x1F1FF},
+	{0x1F201, 0x1F201}, {0x1F21A, 0x1F21A}, {0x1F22F, 0x1F22F},
+	{0x1F232, 0x1F236}, {0x1F238, 0x1F23A}, {0x1F250, 0x1F251},
+	{0x1F300, 0x1F320}, {0x1F32D, 0x1F335}, {0x1F337, 0x1F37C},
+	{0x1F37E, 0x1F393}, {0x1F3A0, 0x1F3CA}, {0x1F3CF, 0x1F3D3},
+	{0x1F3E0, 0x1F3F0}, {0x1F3F4, 0x1F3F4}, {0x1F3F8, 0x1F43E},
+	{0x1F440, 0x1F440}, {0x1F442, 0x1F4FC}, {0x1F4FF, 0x1F53D},
+	{0x1F54B, 0x1F54E}, {0x1F550, 0x1F567}, {0x1F57A, 0x1F57A},
+	{0x1F595, 0x1F596}, {0x1F5A4, 0x1F5A4}, {0x1F5FB, 0x1F64F},
+	{0x1F680, 0x1F6C5}, {0x1F6CC, 0x1F6CC}, {0x1F6D0, 0x1F6D2},
+	{0x1F6D5, 0x1F6D7}, {0x1F6EB, 0x1F6EC}, {0x1F6F4, 0x1F6FC},
+	{0x1F7E0, 0x1F7EB}, {0x1F90C, 0x1F93A}, {0x1F93C, 0x1F945},
+	{0x1F947, 0x1F9FF}, {0x1FA70, 0x1FAFF},
+}
+
+func is_emoji_presentation(r rune) bool {
+	lo, hi := 0, len(emoji_presentation)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		switch {
+		case r < emoji_presentation[mid][0]:
+			hi = mid
+		case r > emoji_presentation[mid][1]:
+			lo = mid + 1
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the number of cells the rune occupies according to the current
+// width policy, 1 or 2.
+func rune_width(r rune) int {
+	w := 0
+	if width_policy.Override != nil {
+		w = width_policy.Override[r]
+	}
+	if w < 1 && width_policy.OverrideFunc != nil {
+		w = width_policy.OverrideFunc(r)
+	}
+	if w < 1 {
+		switch {
+		case runewidth.IsAmbiguousWidth(r):
+			w = width_policy.Ambiguous
+		case width_policy.Emoji != 0 && is_emoji_presentation(r):
+			w = width_policy.Emoji
+		default:
+			w = runewidth.RuneWidth(r)
+		}
+	}
+	if w < 1 {
+		return 1
+	}
+	if w > 2 {
+		return 2
+	}
+	return w
+}
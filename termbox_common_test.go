@@ -0,0 +1,88 @@
+package termbox
+
+import "testing"
+
+func TestRuneWidthPolicy(t *testing.T) {
+	defer SetWidthPolicy(SetWidthPolicy(WidthPolicy{}))
+
+	tests := []struct {
+		policy WidthPolicy
+		r      rune
+		want   int
+	}{
+		{WidthPolicy{}, 'a', 1},
+		{WidthPolicy{}, '́', 1},
+		{WidthPolicy{}, '世', 2},
+		{WidthPolicy{}, '→', 1},
+		{WidthPolicy{Ambiguous: 2}, '→', 2},
+		{WidthPolicy{Emoji: 1}, '😀', 1},
+		{WidthPolicy{Emoji: 2}, '⌚', 2},
+		{WidthPolicy{Override: map[rune]int{'a': 2}}, 'a', 2},
+		{WidthPolicy{OverrideFunc: func(r rune) int { return 0 }}, '世', 2},
+		{WidthPolicy{OverrideFunc: func(r rune) int { return 1 }}, '世', 1},
+	}
+	for _, tt := range tests {
+		SetWidthPolicy(tt.policy)
+		if got := RuneWidth(tt.r); got != tt.want {
+			t.Errorf("RuneWidth(%q) with %+v: want %d got %d", tt.r, tt.policy, tt.want, got)
+		}
+	}
+
+	SetWidthPolicy(WidthPolicy{})
+	if got := StringWidth("a世→"); got != 4 {
+		t.Errorf("StringWidth: want 4 got %d", got)
+	}
+}
+
+func TestKeyText(t *testing.T) {
+	tests := []struct {
+		key  Key
+		name string
+	}{
+		{KeyF5, "F5"},
+		{KeyArrowUp, "Up"},
+		{KeyCtrlX, "Ctrl+X"},
+		{KeyTab, "Tab"},
+		{KeyCtrlSpace, "Ctrl+Space"},
+		{MouseWheelLeft, "MouseWheelLeft"},
+		{KeyUser + 3, "User+3"},
+		{Key(0x1234), "Key(4660)"},
+	}
+	for _, tt := range tests {
+		if got := tt.key.String(); got != tt.name {
+			t.Errorf("%d: want %q, got %q", tt.key, tt.name, got)
+		}
+		text, err := tt.key.MarshalText()
+		var k Key
+		if err == nil {
+			err = k.UnmarshalText(text)
+		}
+		if err != nil || k != tt.key {
+			t.Errorf("%q: round trip gave %d, %v", tt.name, k, err)
+		}
+	}
+
+	var k Key
+	if err := k.UnmarshalText([]byte("pgdn")); err != nil || k != KeyPgdn {
+		t.Errorf("pgdn: got %d, %v", k, err)
+	}
+	if err := k.UnmarshalText([]byte("Nope")); err == nil {
+		t.Errorf("Nope: want an error")
+	}
+}
+
+func TestCtrlKey(t *testing.T) {
+	for _, ch := range "aA@\\" {
+		if _, ok := CtrlKey(ch); !ok {
+			t.Errorf("%q: want a control key", ch)
+		}
+	}
+	if k, _ := CtrlKey('B'); k != KeyCtrlB {
+		t.Errorf("B: want KeyCtrlB, got %v", k)
+	}
+	for _, ch := range "0123456789é" {
+		if k, ok := CtrlKey(ch); ok {
+			t.Errorf("%q: want no control key, got %v", ch, k)
+		}
+	}
+}
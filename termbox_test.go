@@ -0,0 +1,797 @@
+// +build !windows
+
+package termbox
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseClipboardReply(t *testing.T) {
+	defer reset_queries()
+
+	tests := []struct {
+		in        string
+		selection Selection
+		text      string
+	}{
+		{"\033]52;c;aGVsbG8=\a", SelectionClipboard, "hello"},
+		{"\033]52;p;aGVsbG8=\033\\", SelectionPrimary, "hello"},
+		{"\033]52;;\a", SelectionClipboard, ""},
+	}
+	for _, tt := range tests {
+		ev := ParseEvent([]byte(tt.in + "x"))
+		if ev.Type != EventClipboard || ev.N != len(tt.in) {
+			t.Errorf("%q: want clipboard event of %d bytes, got %+v", tt.in, len(tt.in), ev)
+			continue
+		}
+		if ev.Selection != tt.selection || ev.Text != tt.text {
+			t.Errorf("%q: want %q %q, got %q %q", tt.in, tt.selection, tt.text, ev.Selection, ev.Text)
+		}
+	}
+
+	// an incomplete reply is waited for only if it was asked for, and only
+	// until the deadline
+	pipe_output(t)
+	has_osc = true
+	defer func() { has_osc = false }()
+	if err := GetClipboard(SelectionClipboard); err != nil {
+		t.Fatal(err)
+	}
+	ev := Event{Type: EventKey}
+	if got := extract_event([]byte("\033]52;c;aGVs"), &ev, true); got != event_not_extracted && got != event_extracted {
+		t.Errorf("reply before the flush: want no wait, got %d %+v", got, ev)
+	}
+	if err := flush(); err != nil {
+		t.Fatal(err)
+	}
+	ev = Event{Type: EventKey}
+	if got := extract_event([]byte("\033]52;c;aGVs"), &ev, true); got != esc_wait {
+		t.Errorf("incomplete reply: want esc_wait, got %d %+v", got, ev)
+	}
+	if d := esc_wait_delay([]byte("\033]52;c;aGVs")); d < 500*time.Millisecond {
+		t.Errorf("want to wait for the clipboard reply, got %v", d)
+	}
+	// ParseEvent doesn't take replies, it doesn't see the terminal input
+	ParseEvent([]byte("\033]52;c;aGVsbG8=\a"))
+	if !reply_pending(query_clipboard) {
+		t.Errorf("ParseEvent counted a reply")
+	}
+	query_mu.Lock()
+	query_expiry[query_clipboard] = time.Now().Add(-time.Millisecond)
+	query_mu.Unlock()
+	if d := esc_wait_delay([]byte("\033]52;c;aGVs")); d != esc_delay {
+		t.Errorf("want the Esc delay after the deadline, got %v", d)
+	}
+	if reply_pending(query_clipboard) {
+		t.Errorf("the pending reply was not dropped after the deadline")
+	}
+}
+
+func TestParsePaste(t *testing.T) {
+	paste := "\033[200~line 1\rline 2 \033[A\033[201~"
+	ev := ParseEvent([]byte(paste + "x"))
+	if ev.Type != EventPaste || ev.N != len(paste) || ev.Text != "line 1\rline 2 \033[A" {
+		t.Errorf("want paste event of %d bytes, got %+v", len(paste), ev)
+	}
+
+	// feed it byte by byte, the same buffer grows as in PollEvent
+	buf := make([]byte, 0, len(paste))
+	buf = append(buf, paste[:5]...)
+	for i := 5; i < len(paste); i++ {
+		buf = append(buf, paste[i])
+		ev = ParseEvent(buf)
+		if i < len(paste)-1 && (ev.Type != EventNone || ev.N != 0) {
+			t.Fatalf("incomplete paste of %d bytes: want EventNone, got %+v", i+1, ev)
+		}
+	}
+	if ev.Type != EventPaste || ev.Text != "line 1\rline 2 \033[A" {
+		t.Errorf("want paste event, got %+v", ev)
+	}
+}
+
+func TestParseEventSequences(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Event
+	}{
+		{"\033[I", Event{Type: EventFocus, Focused: true, N: 3}},
+		{"\033[O", Event{Type: EventFocus, Focused: false, N: 3}},
+		{"\033[1;5A", Event{Key: KeyArrowUp, Mod: ModCtrl, N: 6}},
+		{"\033[3;2~", Event{Key: KeyDelete, Mod: ModShift, N: 6}},
+		{"\033[15;4~", Event{Key: KeyF5, Mod: ModShift | ModAlt, N: 7}},
+		{"\033[1;9H", Event{Key: KeyHome, Mod: ModMeta, N: 6}},
+		{"\033O5P", Event{Key: KeyF1, Mod: ModCtrl, N: 4}},
+		{"\033[2^", Event{Key: KeyInsert, Mod: ModCtrl, N: 4}},
+		{"\033[6@", Event{Key: KeyPgdn, Mod: ModCtrl | ModShift, N: 4}},
+		{"\033[c", Event{Key: KeyArrowRight, Mod: ModShift, N: 3}},
+		{"\033Od", Event{Key: KeyArrowLeft, Mod: ModCtrl, N: 3}},
+		{"\033[25~", Event{Key: KeyF13, N: 5}},
+		{"\033[34;5~", Event{Key: KeyF20, Mod: ModCtrl, N: 7}},
+		{"\033[Z", Event{Key: KeyBacktab, N: 3}},
+		{"\033OM", Event{Key: KeyKeypadEnter, N: 3}},
+		{"\033[1;3E", Event{Key: KeyBegin, Mod: ModAlt, N: 6}},
+		{"\033[<16;3;4M", Event{Type: EventMouse, Key: MouseLeft, Mod: ModCtrl, MouseX: 2, MouseY: 3, N: 10}},
+		{"\033[<2;1;1m", Event{Type: EventMouse, Key: MouseRelease, Button: MouseRight, N: 9}},
+		{"\033[<3;1;1m", Event{Type: EventMouse, Key: MouseRelease, N: 9}},
+		{"\033[<78;1;1M", Event{Type: EventMouse, Key: MouseWheelLeft, Mod: ModAlt | ModShift, N: 10}},
+		{"\033[<129;1;1M", Event{Type: EventMouse, Key: MouseButton5, N: 11}},
+		{"\033[<196;1;1M", Event{Type: EventMouse, Key: MouseButton8, Mod: ModShift, N: 11}},
+		{"\033[<36;5;1M", Event{Type: EventMouse, Key: MouseLeft, Mod: ModShift | ModMotion, MouseX: 4, N: 10}},
+		{"\033[M#!!", Event{Type: EventMouse, Key: MouseRelease, N: 6}},
+		{"\033[?999xa", Event{Type: EventUnknown, Text: "\033[?999x", N: 7}},
+		{"\033O9za", Event{Type: EventUnknown, Text: "\033O9z", N: 4}},
+		{"\033]99;foo\aa", Event{Type: EventUnknown, Text: "\033]99;foo\a", N: 9}},
+		{"\033P1$r0m\033\\a", Event{Type: EventUnknown, Text: "\033P1$r0m\033\\", N: 9}},
+	}
+	for _, tt := range tests {
+		if got := ParseEvent([]byte(tt.in)); got != tt.want {
+			t.Errorf("%q: want %+v, got %+v", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestParseTerminfoKeys(t *testing.T) {
+	defer func(k []string) {
+		keys = k
+		rebuild_key_trie()
+	}(keys)
+	keys = xterm_keys
+	rebuild_key_trie()
+
+	tests := []struct {
+		in   string
+		want Event
+	}{
+		{"\033Ow", Event{Key: KeyKeypadA1, N: 3}},
+		{"\033Os", Event{Key: KeyKeypadC3, N: 3}},
+		{"\033OE", Event{Key: KeyBegin, N: 3}},
+		{"\033[Z", Event{Key: KeyBacktab, N: 3}},
+		{"\033OC", Event{Key: KeyArrowRight, N: 3}},
+		// xterm's kf13 is Shift+F1
+		{"\033[1;2P", Event{Key: KeyF1, Mod: ModShift, N: 6}},
+		{"\033[24;2~", Event{Key: KeyF12, Mod: ModShift, N: 7}},
+	}
+	for _, tt := range tests {
+		if got := ParseEvent([]byte(tt.in)); got != tt.want {
+			t.Errorf("%q: want %+v, got %+v", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestParseKittyKeys(t *testing.T) {
+	defer func(mode InputMode) {
+		input_mode = mode
+		kitty_pushed = false
+	}(input_mode)
+	input_mode = InputEsc | InputKitty | InputKeyRelease
+	kitty_pushed = true
+
+	tests := []struct {
+		in   string
+		want Event
+	}{
+		{"\033[97u", Event{Ch: 'a'}},
+		{"\033[97;;97u", Event{Ch: 'a', Text: "a"}},
+		{"\033[97:65;2;65u", Event{Ch: 'A', Mod: ModShift, ShiftedCh: 'A', Text: "A"}},
+		{"\033[105;5u", Event{Key: KeyCtrlI, Mod: ModCtrl}},
+		{"\033[9u", Event{Key: KeyTab}},
+		{"\033[13;1:3u", Event{Key: KeyEnter, Action: KeyRelease}},
+		{"\033[1089::97;5u", Event{Key: KeyCtrlA, Mod: ModCtrl, BaseCh: 'a'}},
+		{"\033[32;5u", Event{Key: KeyCtrlSpace, Mod: ModCtrl}},
+		{"\033[1;9:2A", Event{Key: KeyArrowUp, Mod: ModSuper, Action: KeyRepeat}},
+		{"\033[P", Event{Key: KeyF1}},
+		{"\033[57414u", Event{Key: KeyEnter}},
+	}
+	for _, tt := range tests {
+		tt.want.Type = EventKey
+		tt.want.N = len(tt.in)
+		if got := ParseEvent([]byte(tt.in)); got != tt.want {
+			t.Errorf("%q: want %+v, got %+v", tt.in, tt.want, got)
+		}
+	}
+
+	// replies and keys termbox doesn't know about are skipped
+	for _, in := range []string{"\033[?31u", "\033[57441u"} {
+		if got := ParseEvent([]byte(in)); got.Type != EventNone || got.N != len(in) {
+			t.Errorf("%q: want EventNone of %d bytes, got %+v", in, len(in), got)
+		}
+	}
+
+	// a pending query takes the reply
+	query_mu.Lock()
+	add_pending(query_kitty)
+	query_mu.Unlock()
+	if got := ParseEvent([]byte("\033[?31u")); got.Type != EventNone {
+		t.Errorf("reply: want EventNone, got %+v", got)
+	}
+	query_mu.Lock()
+	if !kitty_supported || query_pending[query_kitty] != 0 {
+		t.Errorf("reply to the query wasn't noticed")
+	}
+	kitty_supported = false
+	query_mu.Unlock()
+
+	// until the protocol is pushed, the modifiers are xterm's
+	kitty_pushed = false
+	want := Event{Type: EventKey, Key: KeyArrowUp, Mod: ModMeta, N: 6}
+	if got := ParseEvent([]byte("\033[1;9A")); got != want {
+		t.Errorf("before the push: want %+v, got %+v", want, got)
+	}
+}
+
+func TestParseModifyOtherKeys(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Event
+	}{
+		{"\033[27;6;65~", Event{Key: KeyCtrlA, Mod: ModCtrl | ModShift}},
+		{"\033[27;5;49~", Event{Ch: '1', Mod: ModCtrl}},
+		{"\033[27;5;9~", Event{Key: KeyTab, Mod: ModCtrl}},
+		{"\033[27;3;13~", Event{Key: KeyEnter, Mod: ModAlt}},
+		{"\033[65;6u", Event{Key: KeyCtrlA, Mod: ModCtrl | ModShift}},
+		{"\033[50;5u", Event{Ch: '2', Mod: ModCtrl}},
+		{"\033[51;5u", Event{Ch: '3', Mod: ModCtrl}},
+	}
+	for _, tt := range tests {
+		tt.want.Type = EventKey
+		tt.want.N = len(tt.in)
+		if got := ParseEvent([]byte(tt.in)); got != tt.want {
+			t.Errorf("%q: want %+v, got %+v", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestCoalesceMotion(t *testing.T) {
+	defer func() { inbuf = inbuf[:0] }()
+	inbuf = append(inbuf[:0], "\033[<35;1;1M\033[<35;2;1M\033[<35;3;1M\033[<32;4;1M\033[<35;5;1M"...)
+
+	want := []Event{
+		{Type: EventMouse, Key: MouseHover, Mod: ModMotion, MouseX: 2, N: 10},
+		{Type: EventMouse, Key: MouseLeft, Mod: ModMotion, MouseX: 3, N: 10},
+		{Type: EventMouse, Key: MouseHover, Mod: ModMotion, MouseX: 4, N: 10},
+	}
+	for _, w := range want {
+		var ev Event
+		if extract_next_event(&ev, false) != event_extracted || ev != w {
+			t.Errorf("want %+v, got %+v", w, ev)
+		}
+	}
+	if len(inbuf) != 0 {
+		t.Errorf("want empty input buffer, got %q", inbuf)
+	}
+}
+
+func TestParsePixelMouse(t *testing.T) {
+	defer func(mode InputMode) {
+		input_mode = mode
+		cell_w, cell_h = 0, 0
+		outbuf.Reset()
+		reset_queries()
+	}(input_mode)
+	input_mode = InputEsc | InputMouse | InputPixels
+	pipe_output(t)
+
+	// the report is only taken as a reply while the query is pending, which
+	// is once it is flushed
+	if ev := ParseEvent([]byte("\033[6;16;8t")); ev.Type != EventUnknown {
+		t.Errorf("cell size report without a query: want EventUnknown, got %+v", ev)
+	}
+	request_cell_size()
+	if got := outbuf.String(); got != ti_cell_size+"\033[c" {
+		t.Errorf("want the query in the output buffer, got %q", got)
+	}
+	if ev := ParseEvent([]byte("\033[6;16;8t")); ev.Type != EventUnknown {
+		t.Errorf("cell size report before the flush: want EventUnknown, got %+v", ev)
+	}
+	if err := flush(); err != nil {
+		t.Fatal(err)
+	}
+	if ev := ParseEvent([]byte("\033[6;16;8t")); ev.Type != EventNone || ev.N != 9 {
+		t.Errorf("cell size report: want EventNone of 9 bytes, got %+v", ev)
+	}
+	if w, h := CellSize(); w != 8 || h != 16 {
+		t.Errorf("want cell size 8x16, got %dx%d", w, h)
+	}
+
+	want := Event{Type: EventMouse, Key: MouseLeft, MouseX: 2, MouseY: 1, PixelX: 20, PixelY: 31, N: 11}
+	if ev := ParseEvent([]byte("\033[<0;21;32M")); ev != want {
+		t.Errorf("want %+v, got %+v", want, ev)
+	}
+}
+
+func TestEscWait(t *testing.T) {
+	defer SetEscDelay(SetEscDelay(100 * time.Millisecond))
+
+	tests := []struct {
+		in   string
+		want extract_event_res
+	}{
+		{"\033", esc_wait},
+		{"\033[1;", esc_wait},
+		{"\033[<0;1", esc_wait},
+		{"\033a", event_extracted},
+		{"\033\033", event_extracted},
+	}
+	for _, tt := range tests {
+		ev := Event{Type: EventKey}
+		if got := extract_event([]byte(tt.in), &ev, true); got != tt.want {
+			t.Errorf("%q: want %d, got %d", tt.in, tt.want, got)
+		}
+	}
+
+	SetEscDelay(0)
+	ev := Event{Type: EventKey}
+	if got := extract_event([]byte("\033[1;"), &ev, true); got != event_extracted || ev.Key != KeyEsc {
+		t.Errorf("no delay: want KeyEsc, got %d %+v", got, ev)
+	}
+}
+
+func TestRegisterKey(t *testing.T) {
+	defer func() {
+		user_keys = nil
+		rebuild_key_trie()
+	}()
+	RegisterKey("\033[99~", KeyF5, ModCtrl)
+	RegisterKey("\033[A", KeyUser+1, 0)
+	RegisterKey("\xc2\xa7", KeyUser+2, ModAlt)
+
+	tests := []struct {
+		in   string
+		want Event
+	}{
+		{"\033[99~", Event{Key: KeyF5, Mod: ModCtrl, N: 5}},
+		{"\033[A", Event{Key: KeyUser + 1, N: 3}},
+		{"\xc2\xa7", Event{Key: KeyUser + 2, Mod: ModAlt, N: 2}},
+	}
+	for _, tt := range tests {
+		if got := ParseEvent([]byte(tt.in)); got != tt.want {
+			t.Errorf("%q: want %+v, got %+v", tt.in, tt.want, got)
+		}
+	}
+
+	UnregisterKey("\033[A")
+	if got := ParseEvent([]byte("\033[A")); got.Key != KeyArrowUp {
+		t.Errorf("unregistered: want KeyArrowUp, got %+v", got)
+	}
+
+	// registering while the input is parsed, for the race detector
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			RegisterKey("\033[98~", KeyF6, 0)
+			UnregisterKey("\033[98~")
+		}
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		ParseEvent([]byte("\033[99~"))
+	}
+	<-done
+}
+
+func TestRecordReplay(t *testing.T) {
+	var buf bytes.Buffer
+	if err := StartRecording(&buf); err != nil {
+		t.Fatal(err)
+	}
+	recording.input([]byte("a\033[1;5A"))
+	recording.resize(100, 40)
+	recording.input([]byte("\033"))
+	recording.start = recording.start.Add(-time.Second)
+	recording.input([]byte("b"))
+	if err := StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Replay(&buf, false); err != nil {
+		t.Fatal(err)
+	}
+	want := []Event{
+		{Type: EventKey, Ch: 'a', N: 1},
+		{Type: EventKey, Key: KeyArrowUp, Mod: ModCtrl, N: 6},
+		{Type: EventResize, Width: 100, Height: 40},
+		// a second passed after the Esc
+		{Type: EventKey, Key: KeyEsc, N: 1},
+		{Type: EventKey, Ch: 'b', N: 1},
+		{Type: EventError, Err: ErrReplayDone},
+	}
+	for _, w := range want {
+		if ev := PollEvent(); ev != w {
+			t.Errorf("want %+v, got %+v", w, ev)
+		}
+	}
+
+	if err := Replay(bytes.NewBufferString("termbox-recording 1\n0s in x\n"), false); err == nil {
+		t.Error("bad recording replayed")
+	}
+}
+
+// Redirects the output to a pipe, returns its reading end.
+func pipe_output(t *testing.T) *os.File {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := out
+	out = w
+	t.Cleanup(func() {
+		out = saved
+		r.Close()
+		w.Close()
+	})
+	return r
+}
+
+func TestProbeWidths(t *testing.T) {
+	defer reset_queries()
+	defer SetWidthPolicy(SetWidthPolicy(WidthPolicy{}))
+	defer SetQueryTimeout(SetQueryTimeout(50 * time.Millisecond))
+	r := pipe_output(t)
+
+	probe := func(replies ...string) {
+		t.Helper()
+		done := make(chan struct{})
+		go func() {
+			probe_widths()
+			close(done)
+		}()
+		query := make([]byte, 64)
+		for _, reply := range replies {
+			n, _ := r.Read(query)
+			if !bytes.HasSuffix(query[:n], []byte("\033[m\033[6n")) {
+				t.Errorf("want a concealed probe, got %q", query[:n])
+			}
+			if reply != "" {
+				input_comm <- input_event{data: []byte(reply)}
+				<-input_comm
+			}
+		}
+		<-done
+	}
+
+	probe("\033[1;3R", "\033[1;3R")
+	if p := SetWidthPolicy(WidthPolicy{}); p.Ambiguous != 2 || p.Emoji != 2 {
+		t.Errorf("want both widths 2, got %+v", p)
+	}
+
+	// no reply in time, and then a late one, which isn't a key
+	probe("")
+	if p := SetWidthPolicy(WidthPolicy{}); p.Ambiguous != 0 || p.Emoji != 0 {
+		t.Errorf("without a reply the policy should stay, got %+v", p)
+	}
+	if ev := ParseEvent([]byte("\033[1;2R")); ev.Type != EventNone {
+		t.Errorf("late reply: want EventNone, got %+v", ev)
+	}
+	if len(inbuf) != 0 || len(event_queue) != 0 {
+		t.Errorf("leftovers: %q, %v", inbuf, event_queue)
+	}
+}
+
+func TestCursorPositionReply(t *testing.T) {
+	defer reset_queries()
+
+	// not a reply unless asked for
+	ev := Event{Type: EventKey}
+	if extract_event([]byte("\033[1;5R"), &ev, false); ev.Key != KeyF3 || ev.Mod != ModCtrl {
+		t.Errorf("want Ctrl+F3, got %+v", ev)
+	}
+
+	r := pipe_output(t)
+
+	type result struct {
+		x, y int
+		err  error
+	}
+	done := make(chan result)
+	go func() {
+		x, y, err := GetCursorPosition()
+		done <- result{x, y, err}
+	}()
+
+	// the query reads the input itself, as nothing is in PollEvent
+	query := make([]byte, 16)
+	n, _ := r.Read(query)
+	if string(query[:n]) != "\033[6n" {
+		t.Errorf("want DSR 6, got %q", query[:n])
+	}
+	ie := input_event{data: []byte("x\033[1;5R")}
+	input_comm <- ie
+	<-input_comm
+
+	if res := <-done; res.err != nil || res.x != 4 || res.y != 0 {
+		t.Errorf("want 4, 0, got %+v", res)
+	}
+	if ev := PollEvent(); ev.Ch != 'x' {
+		t.Errorf("want the key read along the way, got %+v", ev)
+	}
+	if len(inbuf) != 0 {
+		t.Errorf("the reply was left in the input: %q", inbuf)
+	}
+}
+
+func TestReplyHold(t *testing.T) {
+	defer reset_queries()
+	defer SetEscDelay(SetEscDelay(0))
+
+	query_mu.Lock()
+	add_pending(query_da1)
+	query_mu.Unlock()
+
+	// only what may still become the reply is held, and not for good
+	tests := []struct {
+		in    string
+		allow bool
+		want  extract_event_res
+	}{
+		{"\033", true, event_extracted},
+		{"\033[", true, esc_wait},
+		{"\033[?6", true, esc_wait},
+		{"\033", false, event_extracted},
+		{"\033[1;", true, event_extracted},
+	}
+	for _, tt := range tests {
+		ev := Event{Type: EventKey}
+		if got := extract_event([]byte(tt.in), &ev, tt.allow); got != tt.want {
+			t.Errorf("%q, %v: want %d, got %d", tt.in, tt.allow, tt.want, got)
+		}
+	}
+	if d := esc_wait_delay([]byte("\033[?6")); d < query_timeout {
+		t.Errorf("want to wait for the reply, got %v", d)
+	}
+	// the Esc key isn't held any longer than without the query
+	SetEscDelay(100 * time.Millisecond)
+	if d := esc_wait_delay([]byte("\033")); d != esc_delay {
+		t.Errorf("bare ESC: want the Esc delay, got %v", d)
+	}
+
+	// a late reply is taken for a while after the query gave up
+	query_mu.Lock()
+	add_pending(query_cursor)
+	query_mu.Unlock()
+	if ev := ParseEvent([]byte("\033[1;5R")); ev.Type != EventNone {
+		t.Errorf("late reply: want EventNone, got %+v", ev)
+	}
+	query_mu.Lock()
+	add_pending(query_cursor)
+	query_expiry[query_cursor] = time.Now().Add(-time.Second)
+	query_mu.Unlock()
+	if ev := ParseEvent([]byte("\033[1;5R")); ev.Key != KeyF3 {
+		t.Errorf("after the grace: want Ctrl+F3, got %+v", ev)
+	}
+}
+
+func TestIdentifyTerminal(t *testing.T) {
+	defer reset_queries()
+
+	r := pipe_output(t)
+
+	tests := []struct {
+		reply string
+		want  TerminalInfo
+	}{
+		{
+			"\033P>|XTerm(380)\033\\\033[>41;380;0c\033[?64;1;2;6;22c",
+			TerminalInfo{Name: "XTerm", Version: "380", Level: 4, Attributes: []int{1, 2, 6, 22}, Type: 41, Firmware: 380},
+		},
+		{
+			"\033P>|WezTerm 20230712\033\\\033[?65;4c",
+			TerminalInfo{Name: "WezTerm", Version: "20230712", Level: 5, Attributes: []int{4}},
+		},
+		// only DA1, the other queries are forgotten
+		{"\033[?1;2c", TerminalInfo{Level: 1, Attributes: []int{2}}},
+	}
+	for _, tt := range tests {
+		type result struct {
+			info TerminalInfo
+			err  error
+		}
+		done := make(chan result)
+		go func() {
+			info, err := IdentifyTerminal()
+			done <- result{info, err}
+		}()
+
+		query := make([]byte, 32)
+		n, _ := r.Read(query)
+		if string(query[:n]) != identify_queries {
+			t.Errorf("want the identification queries, got %q", query[:n])
+		}
+		input_comm <- input_event{data: []byte(tt.reply)}
+		<-input_comm
+
+		res := <-done
+		if res.err != nil || fmt.Sprint(res.info) != fmt.Sprint(tt.want) {
+			t.Errorf("%q: want %+v, got %+v, %v", tt.reply, tt.want, res.info, res.err)
+		}
+		if !query_deadline().IsZero() || len(event_queue) != 0 {
+			t.Errorf("%q: queries left pending or replies leaked: %v", tt.reply, event_queue)
+		}
+	}
+}
+
+func TestQueryColors(t *testing.T) {
+	defer reset_queries()
+	r := pipe_output(t)
+	defer func(saved bool) { has_osc = saved }(has_osc)
+	has_osc = true
+
+	done := make(chan TerminalColors)
+	go func() {
+		colors, err := QueryColors()
+		if err != nil {
+			t.Error(err)
+		}
+		done <- colors
+	}()
+
+	query := make([]byte, 512)
+	n, _ := r.Read(query)
+	if !bytes.HasPrefix(query[:n], []byte("\033]10;?\033\\\033]11;?\033\\\033]4;0;?\033\\")) ||
+		!bytes.HasSuffix(query[:n], []byte("\033]4;15;?\033\\\033[c")) {
+		t.Errorf("want the color queries, got %q", query[:n])
+	}
+	// some of the palette is not reported
+	reply := "\033]10;rgb:ffff/ffff/ffff\033\\\033]11;rgb:0000/2b2b/3636\a" +
+		"\033]4;1;rgb:cd/00/00\033\\\033]4;15;#fff\033\\\033[?62;22c"
+	input_comm <- input_event{data: []byte(reply)}
+	<-input_comm
+
+	colors := <-done
+	want := TerminalColors{
+		Foreground: RGBToAttribute(255, 255, 255),
+		Background: RGBToAttribute(0, 0x2b, 0x36),
+	}
+	want.Palette[1] = RGBToAttribute(0xcd, 0, 0)
+	want.Palette[15] = RGBToAttribute(255, 255, 255)
+	if colors != want {
+		t.Errorf("want %v, got %v", want, colors)
+	}
+	if !colors.DarkBackground() {
+		t.Error("the background should be dark")
+	}
+	if !query_deadline().IsZero() || len(event_queue) != 0 {
+		t.Errorf("queries left pending or replies leaked: %v", event_queue)
+	}
+
+	colors.Background = RGBToAttribute(0xfd, 0xf6, 0xe3)
+	if colors.DarkBackground() {
+		t.Error("the background should be light")
+	}
+}
+
+func TestSendLink(t *testing.T) {
+	defer func(saved bool) { has_hyperlinks = saved }(has_hyperlinks)
+	has_hyperlinks = true
+	defer outbuf.Reset()
+
+	tests := []struct {
+		link Hyperlink
+		want string
+	}{
+		{Hyperlink{URI: "http://x/a b", ID: "1"}, "\033]8;id=1;http://x/a b\033\\"},
+		{Hyperlink{URI: "http://x/\033]0;pwned\aé"}, "\033]8;;http://x/%1B]0;pwned%07%C3%A9\033\\"},
+		{Hyperlink{URI: "http://x/", ID: "a:b;c"}, "\033]8;;http://x/\033\\"},
+	}
+	for _, tt := range tests {
+		outbuf.Reset()
+		lastlink = nil
+		link := tt.link
+		send_link(&link)
+		if got := outbuf.String(); got != tt.want {
+			t.Errorf("%+v: want %q, got %q", tt.link, tt.want, got)
+		}
+	}
+	lastlink = nil
+}
+
+func TestCursorStyleAndColor(t *testing.T) {
+	defer func(ext map[string]string, osc bool) {
+		ti_ext, has_osc = ext, osc
+		cursor_style_changed, cursor_color_changed = false, false
+		outbuf.Reset()
+	}(ti_ext, has_osc)
+	r := pipe_output(t)
+
+	ti_ext = map[string]string{"Ss": "\033[%p1%d q", "Se": "\033[2 q"}
+	has_osc = true
+	outbuf.Reset()
+	SetCursorStyle(CursorSteadyBar)
+	SetCursorColor(RGBToAttribute(255, 0, 0))
+	if got, want := outbuf.String(), "\033[6 q\033]12;rgb:ff/00/00\033\\"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	// Close brings back the terminal's style and color
+	restore_cursor()
+	buf := make([]byte, 64)
+	n, _ := r.Read(buf)
+	if got, want := string(buf[:n]), "\033[2 q\033]112\033\\"; got != want {
+		t.Errorf("restore: want %q, got %q", want, got)
+	}
+
+	// without Se, Ss with the default style, and no OSC on the console
+	delete(ti_ext, "Se")
+	has_osc = false
+	outbuf.Reset()
+	SetCursorColor(ColorRed)
+	if outbuf.Len() != 0 {
+		t.Errorf("OSC 12 without OSC support: %q", outbuf.String())
+	}
+	restore_cursor()
+	n, _ = r.Read(buf)
+	if got, want := string(buf[:n]), "\033[0 q"; got != want {
+		t.Errorf("restore without Se: want %q, got %q", want, got)
+	}
+}
+
+func TestWriteTitle(t *testing.T) {
+	defer func(f []string, osc bool) { funcs, has_osc, title_pushed = f, osc, false }(funcs, has_osc)
+	defer outbuf.Reset()
+	funcs = make([]string, t_max_funcs)
+
+	tests := []struct {
+		tsl, fsl string
+		osc      bool
+		ps       int
+		want     string
+	}{
+		{"", "", true, 0, "\033[22;0t\033]0;a\033\\"},
+		{"\033]2;", "\a", true, 2, "\033[22;0t\033]2;a\a"},
+		{"\033]0;%p1%d;", "\a", false, 2, "\033]0;0;a\a"},
+		// parameters we don't understand, OSC 2 instead
+		{"\033[%i%p1%dH", "\a", true, 2, "\033[22;0t\033]2;a\033\\"},
+		{"", "", false, 1, ""},
+	}
+	for _, tt := range tests {
+		outbuf.Reset()
+		title_pushed = false
+		funcs[t_enter_title], funcs[t_exit_title], has_osc = tt.tsl, tt.fsl, tt.osc
+		write_title(tt.ps, "a")
+		if got := outbuf.String(); got != tt.want {
+			t.Errorf("%+v: want %q, got %q", tt, tt.want, got)
+		}
+		if title_pushed != tt.osc {
+			t.Errorf("%+v: title_pushed is %v", tt, title_pushed)
+		}
+	}
+}
+
+func TestLongPaste(t *testing.T) {
+	defer func(limit int) {
+		paste_limit = limit
+		paste_continues = false
+		inbuf = inbuf[:0]
+	}(paste_limit)
+	paste_limit = 8
+
+	var got []Event
+	feed := func(s string) {
+		inbuf = append(inbuf, s...)
+		var ev Event
+		for extract_next_event(&ev, false) == event_extracted {
+			got = append(got, ev)
+		}
+	}
+	feed("\033[200~0123")
+	feed("4567é89")
+	feed("\033[201")
+	feed("~x")
+
+	var text string
+	for _, ev := range got[:len(got)-1] {
+		if ev.Type != EventPaste {
+			t.Fatalf("want paste events, got %+v", ev)
+		}
+		text += ev.Text
+	}
+	if len(got) < 3 || text != "01234567é89" {
+		t.Errorf("want the paste split in pieces, got %+v", got)
+	}
+	if last := got[len(got)-1]; last.Ch != 'x' {
+		t.Errorf("want 'x' after the paste, got %+v", last)
+	}
+}
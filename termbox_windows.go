@@ -2,6 +2,7 @@ package termbox
 
 import "math"
 import "syscall"
+import "time"
 import "unsafe"
 import "unicode/utf16"
 import "github.com/mattn/go-runewidth"
@@ -58,6 +59,9 @@ type (
 		control_key_state dword
 		event_flags       dword
 	}
+	focus_event_record struct {
+		set_focus int32
+	}
 	console_font_info struct {
 		font      uint32
 		font_size coord
@@ -65,11 +69,14 @@ type (
 )
 
 const (
-	mouse_lmb = 0x1
-	mouse_rmb = 0x2
-	mouse_mmb = 0x4 | 0x8 | 0x10
-	SM_CXMIN  = 28
-	SM_CYMIN  = 29
+	mouse_lmb   = 0x1
+	mouse_rmb   = 0x2
+	mouse_mmb   = 0x4
+	mouse_xb1   = 0x8
+	mouse_xb2   = 0x10
+	focus_event = 0x10
+	SM_CXMIN    = 28
+	SM_CYMIN    = 29
 )
 
 func (this coord) uintptr() uintptr {
@@ -105,6 +112,8 @@ var (
 	proc_wait_for_multiple_objects        = kernel32.NewProc("WaitForMultipleObjects")
 	proc_set_event                        = kernel32.NewProc("SetEvent")
 	proc_get_current_console_font         = kernel32.NewProc("GetCurrentConsoleFont")
+	proc_get_console_title                = kernel32.NewProc("GetConsoleTitleW")
+	proc_set_console_title                = kernel32.NewProc("SetConsoleTitleW")
 	get_system_metrics                    = moduser32.NewProc("GetSystemMetrics")
 )
 
@@ -380,6 +389,34 @@ func get_current_console_font(h syscall.Handle, info *console_font_info) (err er
 	return
 }
 
+func get_console_title() (title []uint16, err error) {
+	buf := make([]uint16, 1024)
+	r0, _, e1 := syscall.Syscall(proc_get_console_title.Addr(),
+		2, uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), 0)
+	if int(r0) == 0 {
+		if e1 != 0 {
+			err = error(e1)
+		} else {
+			err = syscall.EINVAL
+		}
+		return nil, err
+	}
+	return buf[:r0+1], nil
+}
+
+func set_console_title(title []uint16) (err error) {
+	r0, _, e1 := syscall.Syscall(proc_set_console_title.Addr(),
+		1, uintptr(unsafe.Pointer(&title[0])), 0, 0)
+	if int(r0) == 0 {
+		if e1 != 0 {
+			err = error(e1)
+		} else {
+			err = syscall.EINVAL
+		}
+	}
+	return
+}
+
 type diff_msg struct {
 	pos   short
 	lines short
@@ -403,6 +440,8 @@ var (
 	input_mode       = InputEsc
 	cursor_x         = cursor_hidden
 	cursor_y         = cursor_hidden
+	cursor_size      = dword(100)
+	orig_title       []uint16
 	foreground       = ColorDefault
 	background       = ColorDefault
 	in               syscall.Handle
@@ -418,6 +457,8 @@ var (
 	cancel_comm      = make(chan bool, 1)
 	cancel_done_comm = make(chan bool)
 	alt_mode_esc     = false
+	esc_delay        = time.Duration(default_esc_delay)
+	query_timeout    = time.Second
 
 	// these ones just to prevent heap allocs at all costs
 	tmp_info   console_screen_buffer_info
@@ -559,10 +600,7 @@ func append_diff_line(y int) int {
 		charbuf = append(charbuf, char_info{attr: attr, char: char[0]})
 		*front = *back
 		n++
-		w := runewidth.RuneWidth(back.Ch)
-		if w == 0 || w == 2 && runewidth.IsAmbiguousWidth(back.Ch) {
-			w = 1
-		}
+		w := rune_width(back.Ch)
 		x += w
 		// If not CJK, fill trailing space with whitespace
 		if !is_cjk && w == 2 {
@@ -659,7 +697,7 @@ func show_cursor(visible bool) {
 	}
 
 	var info console_cursor_info
-	info.size = 100
+	info.size = cursor_size
 	info.visible = v
 	err := set_console_cursor_info(out, &info)
 	if err != nil {
@@ -670,9 +708,9 @@ func show_cursor(visible bool) {
 func clear() {
 	var err error
 	attr, char := cell_to_char_info(Cell{
-		' ',
-		foreground,
-		background,
+		Ch: ' ',
+		Fg: foreground,
+		Bg: background,
 	})
 
 	area := int(term_size.x) * int(term_size.y)
@@ -689,6 +727,21 @@ func clear() {
 	}
 }
 
+// Modifiers for the special keys, which can't be expressed otherwise.
+func control_key_state_to_mod(state dword) Modifier {
+	var mod Modifier
+	if state&(left_alt_pressed|right_alt_pressed) != 0 {
+		mod |= ModAlt
+	}
+	if state&(left_ctrl_pressed|right_ctrl_pressed) != 0 {
+		mod |= ModCtrl
+	}
+	if state&shift_pressed != 0 {
+		mod |= ModShift
+	}
+	return mod
+}
+
 func key_event_record_to_event(r *key_event_record) (Event, bool) {
 	if r.key_down == 0 {
 		return Event{}, false
@@ -707,7 +760,7 @@ func key_event_record_to_event(r *key_event_record) (Event, bool) {
 
 	ctrlpressed := r.control_key_state&(left_ctrl_pressed|right_ctrl_pressed) != 0
 
-	if r.virtual_key_code >= vk_f1 && r.virtual_key_code <= vk_f12 {
+	if r.virtual_key_code >= vk_f1 && r.virtual_key_code <= vk_f24 {
 		switch r.virtual_key_code {
 		case vk_f1:
 			e.Key = KeyF1
@@ -734,9 +787,18 @@ func key_event_record_to_event(r *key_event_record) (Event, bool) {
 		case vk_f12:
 			e.Key = KeyF12
 		default:
-			panic("unreachable")
+			// F13-F24 follow each other, both as virtual key codes
+			// and as termbox keys
+			e.Key = KeyF13 - Key(r.virtual_key_code-vk_f13)
 		}
 
+		e.Mod |= control_key_state_to_mod(r.control_key_state)
+		return e, true
+	}
+
+	if r.virtual_key_code == vk_apps {
+		e.Key = KeyMenu
+		e.Mod |= control_key_state_to_mod(r.control_key_state)
 		return e, true
 	}
 
@@ -769,7 +831,19 @@ func key_event_record_to_event(r *key_event_record) (Event, bool) {
 				e.Key = KeyBackspace
 			}
 		case vk_tab:
-			e.Key = KeyTab
+			if r.control_key_state&shift_pressed != 0 {
+				e.Key = KeyBacktab
+			} else {
+				e.Key = KeyTab
+			}
+		case vk_clear:
+			e.Key = KeyBegin
+		case vk_pause:
+			e.Key = KeyPause
+		case vk_select:
+			e.Key = KeySelect
+		case vk_snapshot:
+			e.Key = KeyPrint
 		case vk_enter:
 			if ctrlpressed {
 				e.Key = KeyCtrlJ
@@ -794,6 +868,14 @@ func key_event_record_to_event(r *key_event_record) (Event, bool) {
 			}
 		}
 
+		if e.Key > key_min || e.Key <= KeyF13 && e.Key >= KeySelect {
+			// navigation and system keys
+			e.Mod |= control_key_state_to_mod(r.control_key_state)
+			if e.Key == KeyBacktab {
+				// Shift is implied
+				e.Mod &^= ModShift
+			}
+		}
 		if e.Key != 0 {
 			return e, true
 		}
@@ -886,6 +968,14 @@ func input_event_producer() {
 				Width:  int(sr.size.x),
 				Height: int(sr.size.y),
 			}
+		case focus_event:
+			fr := *(*focus_event_record)(unsafe.Pointer(&r.event))
+			if input_mode&InputFocus != 0 {
+				input_comm <- Event{
+					Type:    EventFocus,
+					Focused: fr.set_focus != 0,
+				}
+			}
 		case mouse_event:
 			mr := *(*mouse_event_record)(unsafe.Pointer(&r.event))
 			ev := Event{Type: EventMouse}
@@ -903,12 +993,27 @@ func input_event_producer() {
 				case last_state&mouse_mmb == 0 && cur_state&mouse_mmb != 0:
 					last_button = MouseMiddle
 					last_button_pressed = last_button
+				case last_state&mouse_xb1 == 0 && cur_state&mouse_xb1 != 0:
+					last_button = MouseButton4
+					last_button_pressed = last_button
+				case last_state&mouse_xb2 == 0 && cur_state&mouse_xb2 != 0:
+					last_button = MouseButton5
+					last_button_pressed = last_button
 				case last_state&mouse_lmb != 0 && cur_state&mouse_lmb == 0:
 					last_button = MouseRelease
+					ev.Button = MouseLeft
 				case last_state&mouse_rmb != 0 && cur_state&mouse_rmb == 0:
 					last_button = MouseRelease
+					ev.Button = MouseRight
 				case last_state&mouse_mmb != 0 && cur_state&mouse_mmb == 0:
 					last_button = MouseRelease
+					ev.Button = MouseMiddle
+				case last_state&mouse_xb1 != 0 && cur_state&mouse_xb1 == 0:
+					last_button = MouseRelease
+					ev.Button = MouseButton4
+				case last_state&mouse_xb2 != 0 && cur_state&mouse_xb2 == 0:
+					last_button = MouseRelease
+					ev.Button = MouseButton5
 				default:
 					last_state = cur_state
 					continue
@@ -927,6 +1032,12 @@ func input_event_producer() {
 					ev.MouseX = x
 					ev.MouseY = y
 					last_x, last_y = x, y
+				} else if input_mode&InputHover != 0 && (last_x != x || last_y != y) {
+					ev.Key = MouseHover
+					ev.Mod = ModMotion
+					ev.MouseX = x
+					ev.MouseY = y
+					last_x, last_y = x, y
 				} else {
 					ev.Type = EventNone
 				}
@@ -941,10 +1052,22 @@ func input_event_producer() {
 				last_x, last_y = int(mr.mouse_pos.x), int(mr.mouse_pos.y)
 				ev.MouseX = last_x
 				ev.MouseY = last_y
+			case 8:
+				// horizontal mouse wheel
+				n := int16(mr.button_state >> 16)
+				if n > 0 {
+					ev.Key = MouseWheelRight
+				} else {
+					ev.Key = MouseWheelLeft
+				}
+				last_x, last_y = int(mr.mouse_pos.x), int(mr.mouse_pos.y)
+				ev.MouseX = last_x
+				ev.MouseY = last_y
 			default:
 				ev.Type = EventNone
 			}
 			if ev.Type != EventNone {
+				ev.Mod |= control_key_state_to_mod(mr.control_key_state)
 				input_comm <- ev
 			}
 		}
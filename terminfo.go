@@ -2,10 +2,11 @@
 // This file contains a simple and incomplete implementation of the terminfo
 // database. Information was taken from the ncurses manpages term(5) and
 // terminfo(5). Currently, only the string capabilities for special keys and for
-// functions without parameters are actually used. Colors are still done with
-// ANSI escape sequences. Other special features that are not (yet?) supported
-// are reading from ~/.terminfo, the TERMINFO_DIRS variable, Berkeley database
-// format and extended capabilities.
+// functions without parameters are actually used, plus a few string
+// capabilities from the extended section (see ti_ext). Colors are still done
+// with ANSI escape sequences. Other special features that are not (yet?)
+// supported are reading from ~/.terminfo, the TERMINFO_DIRS variable and
+// Berkeley database format.
 
 package termbox
 
@@ -17,6 +18,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -25,6 +27,31 @@ const (
 	ti_header_length = 12
 	ti_mouse_enter   = "\x1b[?1000h\x1b[?1002h\x1b[?1015h\x1b[?1006h"
 	ti_mouse_leave   = "\x1b[?1006l\x1b[?1015l\x1b[?1002l\x1b[?1000l"
+	ti_hover_enter   = "\x1b[?1003h"
+	ti_hover_leave   = "\x1b[?1003l"
+	ti_pixels_enter  = "\x1b[?1016h"
+	ti_pixels_leave  = "\x1b[?1016l"
+	ti_cell_size     = "\x1b[16t"
+	ti_paste_enter   = "\x1b[?2004h"
+	ti_paste_leave   = "\x1b[?2004l"
+	ti_paste_start   = "\x1b[200~"
+	ti_paste_end     = "\x1b[201~"
+	ti_focus_enter   = "\x1b[?1004h"
+	ti_focus_leave   = "\x1b[?1004l"
+	ti_focus_in      = "\x1b[I"
+	ti_focus_out     = "\x1b[O"
+
+	// kitty keyboard protocol: disambiguate escape codes (1), alternate keys
+	// (4), all keys as escape codes (8) and associated text (16), and with
+	// InputKeyRelease, event types (2) too
+	ti_kitty_push         = "\x1b[>29u"
+	ti_kitty_push_release = "\x1b[>31u"
+	ti_kitty_pop          = "\x1b[<u"
+	ti_kitty_query        = "\x1b[?u"
+
+	// xterm's modifyOtherKeys, level 2 and back to the default
+	ti_other_keys_enter = "\x1b[>4;2m"
+	ti_other_keys_leave = "\x1b[>4m"
 )
 
 func load_terminfo() ([]byte, error) {
@@ -96,12 +123,35 @@ func ti_try_path(path string) (data []byte, err error) {
 	return
 }
 
+// Unknown OSC sequences are ignored by most terminals, but these ones print
+// garbage instead.
+func ti_supports_osc(name string) bool {
+	for _, prefix := range []string{"linux", "dumb", "cons"} {
+		if strings.HasPrefix(name, prefix) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// OSC 8 hyperlinks on top of that are known to mess up the screen on these.
+func ti_supports_hyperlinks(name string) bool {
+	for _, prefix := range []string{"Eterm", "screen"} {
+		if strings.HasPrefix(name, prefix) {
+			return false
+		}
+	}
+	return ti_supports_osc(name)
+}
+
 func setup_term_builtin() error {
 	name := os.Getenv("TERM")
 	if name == "" {
 		return errors.New("termbox: TERM environment variable not set")
 	}
 
+	ti_ext = ti_builtin_ext(name)
+	mod_keys = nil
 	for _, t := range terms {
 		if t.name == name {
 			keys = t.keys
@@ -142,6 +192,9 @@ func setup_term() (err error) {
 	var header [6]int16
 	var str_offset, table_offset int16
 
+	has_osc = ti_supports_osc(os.Getenv("TERM"))
+	has_hyperlinks = ti_supports_hyperlinks(os.Getenv("TERM"))
+
 	data, err = load_terminfo()
 	if err != nil {
 		return setup_term_builtin()
@@ -169,8 +222,11 @@ func setup_term() (err error) {
 	str_offset = ti_header_length + header[1] + header[2] + number_sec_len*header[3]
 	table_offset = str_offset + 2*header[4]
 
-	keys = make([]string, 0xFFFF-key_min)
+	keys = make([]string, len(ti_keys))
 	for i, _ := range keys {
+		if ti_keys[i] < 0 {
+			continue
+		}
 		keys[i], err = ti_read_string(rd, str_offset+2*ti_keys[i], table_offset)
 		if err != nil {
 			return
@@ -187,9 +243,139 @@ func setup_term() (err error) {
 	}
 	funcs[t_max_funcs-2] = ti_mouse_enter
 	funcs[t_max_funcs-1] = ti_mouse_leave
+
+	// the extended section follows the string table, aligned on a word
+	// boundary
+	ext_offset := int(table_offset) + int(header[5])
+	ti_ext = ti_read_extended(data, ext_offset+ext_offset%2, int(number_sec_len))
+	mod_keys = ti_mod_keys(ti_ext)
 	return nil
 }
 
+// Builds the list of modified key sequences from the extended capabilities.
+// Their names are the base name followed by the xterm modifier parameter, e.g.
+// kUP5 is Ctrl+Up and kDC2 is Shift+Delete.
+func ti_mod_keys(ext map[string]string) []mod_key {
+	var mks []mod_key
+	for _, it := range ti_mod_key_names {
+		for m := 2; m <= 8; m++ {
+			seq := ext[it.name+strconv.Itoa(m)]
+			if seq == "" {
+				continue
+			}
+			mks = append(mks, mod_key{seq, it.key, xterm_modifier(m)})
+		}
+	}
+	return mks
+}
+
+// The builtin tables have no extended capabilities, these are the ones which
+// are known to work on the builtin terminals.
+func ti_builtin_ext(name string) map[string]string {
+	ext := map[string]string{}
+	for _, partial := range []string{"xterm", "rxvt", "cygwin", "st"} {
+		if strings.Contains(name, partial) {
+			ext["Ss"] = "\x1b[%p1%d q"
+			ext["Se"] = "\x1b[2 q"
+			break
+		}
+	}
+	return ext
+}
+
+// Reads the string capabilities of the extended section (the one with
+// user-defined capabilities, see term(5)) starting at 'off'. Returns an empty
+// map if there is no such section or it's malformed.
+func ti_read_extended(data []byte, off int, number_sec_len int) map[string]string {
+	ext := map[string]string{}
+	short := func(i int) int {
+		return int(int16(binary.LittleEndian.Uint16(data[i:])))
+	}
+
+	// 0: number of booleans, 1: number of numbers, 2: number of strings,
+	// 3: number of offsets (strings and names), 4: size of the string table
+	if off+10 > len(data) {
+		return ext
+	}
+	var header [5]int
+	for i := range header {
+		header[i] = short(off + 2*i)
+	}
+	for _, h := range header {
+		if h < 0 {
+			return ext
+		}
+	}
+	nbools, nnums, nstrs := header[0], header[1], header[2]
+
+	off += 10 + nbools + nbools%2 + nnums*number_sec_len
+	names_off := off + 2*nstrs
+	table_off := names_off + 2*(nbools+nnums+nstrs)
+	if table_off+header[4] > len(data) {
+		return ext
+	}
+	table := data[table_off : table_off+header[4]]
+
+	cstring := func(t []byte, i int) (string, bool) {
+		if i < 0 || i >= len(t) {
+			return "", false
+		}
+		end := bytes.IndexByte(t[i:], 0)
+		if end == -1 {
+			return "", false
+		}
+		return string(t[i : i+end]), true
+	}
+
+	// values go first, names are right after the last value and their
+	// offsets are relative to that point
+	values := make([]string, nstrs)
+	present := make([]bool, nstrs)
+	names_base := 0
+	for i := range values {
+		voff := short(off + 2*i)
+		if voff < 0 {
+			continue
+		}
+		values[i], present[i] = cstring(table, voff)
+		if present[i] && voff+len(values[i])+1 > names_base {
+			names_base = voff + len(values[i]) + 1
+		}
+	}
+	if names_base > len(table) {
+		return ext
+	}
+	for i := range values {
+		if !present[i] {
+			continue
+		}
+		name, ok := cstring(table[names_base:], short(names_off+2*(nbools+nnums+i)))
+		if ok {
+			ext[name] = values[i]
+		}
+	}
+	return ext
+}
+
+// Returns the extended capability 'name' or 'def' if the terminal doesn't have
+// it.
+func ti_ext_or(name, def string) string {
+	if s, ok := ti_ext[name]; ok && s != "" {
+		return s
+	}
+	return def
+}
+
+// Expands a parametrized string capability with a single numeric argument.
+// Only the trivial "%p1%d" form is understood, which is what the capabilities
+// we use look like in practice. Returns false for anything else.
+func ti_param1(capability string, n int) (string, bool) {
+	if strings.Count(capability, "%") != 2 || !strings.Contains(capability, "%p1%d") {
+		return "", false
+	}
+	return strings.Replace(capability, "%p1%d", strconv.Itoa(n), 1), true
+}
+
 func ti_read_string(rd *bytes.Reader, str_off, table int16) (string, error) {
 	var off int16
 
@@ -201,6 +387,10 @@ func ti_read_string(rd *bytes.Reader, str_off, table int16) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if off < 0 {
+		// -1 means absent, -2 means cancelled
+		return "", nil
+	}
 	_, err = rd.Seek(int64(table+off), 0)
 	if err != nil {
 		return "", err
@@ -237,10 +427,42 @@ var ti_funcs = []int16{
 	34,  // reverse
 	89,  // enter keypad ("keypad_xmit")
 	88,  // exit keypad ("keypad_local")
+	135, // to status line, used for the window title
+	47,  // from status line
+}
+
+// Extended capabilities for the modified keys, see ti_mod_keys.
+var ti_mod_key_names = []struct {
+	name string
+	key  Key
+}{
+	{"kUP", KeyArrowUp},
+	{"kDN", KeyArrowDown},
+	{"kLFT", KeyArrowLeft},
+	{"kRIT", KeyArrowRight},
+	{"kHOM", KeyHome},
+	{"kEND", KeyEnd},
+	{"kIC", KeyInsert},
+	{"kDC", KeyDelete},
+	{"kPRV", KeyPgup},
+	{"kNXT", KeyPgdn},
 }
 
 // Same as above for the special keys.
 var ti_keys = []int16{
 	66, 68 /* apparently not a typo; 67 is F10 for whatever reason */, 69, 70,
 	71, 72, 73, 74, 75, 67, 216, 217, 77, 59, 76, 164, 82, 81, 87, 61, 79, 83,
+	218, 219, 220, 221, 222, 223, 224, 225, 226, 227, 228, 229, // F13-F24
+	148, 165, 139, 140, 141, 142, 143, 158,
+	-1 /* there is no capability for Menu */, 176, -1 /* nor Pause */, 167, 193,
+}
+
+// Returns the key of the i-th entry of ti_keys (and of keys). The first ones
+// count down from KeyF1 to key_min, the ones added later from KeyF13, after
+// the mouse keys.
+func ti_key(i int) Key {
+	if n := int(0xFFFF - key_min); i >= n {
+		return KeyF13 - Key(i-n)
+	}
+	return Key(0xFFFF - i)
 }
@@ -4,7 +4,7 @@ package termbox
 
 // Eterm
 var eterm_keys = []string{
-	"\x1b[11~", "\x1b[12~", "\x1b[13~", "\x1b[14~", "\x1b[15~", "\x1b[17~", "\x1b[18~", "\x1b[19~", "\x1b[20~", "\x1b[21~", "\x1b[23~", "\x1b[24~", "\x1b[2~", "\x1b[3~", "\x1b[7~", "\x1b[8~", "\x1b[5~", "\x1b[6~", "\x1b[A", "\x1b[B", "\x1b[D", "\x1b[C",
+	"\x1b[11~", "\x1b[12~", "\x1b[13~", "\x1b[14~", "\x1b[15~", "\x1b[17~", "\x1b[18~", "\x1b[19~", "\x1b[20~", "\x1b[21~", "\x1b[23~", "\x1b[24~", "\x1b[2~", "\x1b[3~", "\x1b[7~", "\x1b[8~", "\x1b[5~", "\x1b[6~", "\x1b[A", "\x1b[B", "\x1b[D", "\x1b[C", "\x1b[25~", "\x1b[26~", "\x1b[28~", "\x1b[29~", "\x1b[31~", "\x1b[32~", "\x1b[33~", "\x1b[34~", "\x1b[23$", "\x1b[24$", "\x1b[11^", "\x1b[12^", "", "\x1bOM", "", "", "\x1bOu", "", "", "\x1bOu", "", "", "", "\x1b[1~", "\x1b[4~",
 }
 var eterm_funcs = []string{
 	t_enter_ca:     "\x1b7\x1b[?47h",
@@ -22,13 +22,15 @@ var eterm_funcs = []string{
 	t_reverse:      "\x1b[7m",
 	t_enter_keypad: "",
 	t_exit_keypad:  "",
+	t_enter_title:  "",
+	t_exit_title:   "",
 	t_enter_mouse:  "",
 	t_exit_mouse:   "",
 }
 
 // screen
 var screen_keys = []string{
-	"\x1bOP", "\x1bOQ", "\x1bOR", "\x1bOS", "\x1b[15~", "\x1b[17~", "\x1b[18~", "\x1b[19~", "\x1b[20~", "\x1b[21~", "\x1b[23~", "\x1b[24~", "\x1b[2~", "\x1b[3~", "\x1b[1~", "\x1b[4~", "\x1b[5~", "\x1b[6~", "\x1bOA", "\x1bOB", "\x1bOD", "\x1bOC",
+	"\x1bOP", "\x1bOQ", "\x1bOR", "\x1bOS", "\x1b[15~", "\x1b[17~", "\x1b[18~", "\x1b[19~", "\x1b[20~", "\x1b[21~", "\x1b[23~", "\x1b[24~", "\x1b[2~", "\x1b[3~", "\x1b[1~", "\x1b[4~", "\x1b[5~", "\x1b[6~", "\x1bOA", "\x1bOB", "\x1bOD", "\x1bOC", "", "", "", "", "", "", "", "", "", "", "", "", "\x1b[Z", "", "", "", "", "", "", "", "", "", "", "", "",
 }
 var screen_funcs = []string{
 	t_enter_ca:     "\x1b[?1049h",
@@ -46,13 +48,15 @@ var screen_funcs = []string{
 	t_reverse:      "\x1b[7m",
 	t_enter_keypad: "\x1b[?1h\x1b=",
 	t_exit_keypad:  "\x1b[?1l\x1b>",
+	t_enter_title:  "",
+	t_exit_title:   "",
 	t_enter_mouse:  ti_mouse_enter,
 	t_exit_mouse:   ti_mouse_leave,
 }
 
 // xterm
 var xterm_keys = []string{
-	"\x1bOP", "\x1bOQ", "\x1bOR", "\x1bOS", "\x1b[15~", "\x1b[17~", "\x1b[18~", "\x1b[19~", "\x1b[20~", "\x1b[21~", "\x1b[23~", "\x1b[24~", "\x1b[2~", "\x1b[3~", "\x1bOH", "\x1bOF", "\x1b[5~", "\x1b[6~", "\x1bOA", "\x1bOB", "\x1bOD", "\x1bOC",
+	"\x1bOP", "\x1bOQ", "\x1bOR", "\x1bOS", "\x1b[15~", "\x1b[17~", "\x1b[18~", "\x1b[19~", "\x1b[20~", "\x1b[21~", "\x1b[23~", "\x1b[24~", "\x1b[2~", "\x1b[3~", "\x1bOH", "\x1bOF", "\x1b[5~", "\x1b[6~", "\x1bOA", "\x1bOB", "\x1bOD", "\x1bOC", "\x1b[1;2P", "\x1b[1;2Q", "\x1b[1;2R", "\x1b[1;2S", "\x1b[15;2~", "\x1b[17;2~", "\x1b[18;2~", "\x1b[19;2~", "\x1b[20;2~", "\x1b[21;2~", "\x1b[23;2~", "\x1b[24;2~", "\x1b[Z", "\x1bOM", "\x1bOw", "\x1bOy", "\x1bOu", "\x1bOq", "\x1bOs", "\x1bOE", "", "", "", "", "",
 }
 var xterm_funcs = []string{
 	t_enter_ca:     "\x1b[?1049h",
@@ -70,13 +74,15 @@ var xterm_funcs = []string{
 	t_reverse:      "\x1b[7m",
 	t_enter_keypad: "\x1b[?1h\x1b=",
 	t_exit_keypad:  "\x1b[?1l\x1b>",
+	t_enter_title:  "",
+	t_exit_title:   "",
 	t_enter_mouse:  ti_mouse_enter,
 	t_exit_mouse:   ti_mouse_leave,
 }
 
 // rxvt-unicode
 var rxvt_unicode_keys = []string{
-	"\x1b[11~", "\x1b[12~", "\x1b[13~", "\x1b[14~", "\x1b[15~", "\x1b[17~", "\x1b[18~", "\x1b[19~", "\x1b[20~", "\x1b[21~", "\x1b[23~", "\x1b[24~", "\x1b[2~", "\x1b[3~", "\x1b[7~", "\x1b[8~", "\x1b[5~", "\x1b[6~", "\x1b[A", "\x1b[B", "\x1b[D", "\x1b[C",
+	"\x1b[11~", "\x1b[12~", "\x1b[13~", "\x1b[14~", "\x1b[15~", "\x1b[17~", "\x1b[18~", "\x1b[19~", "\x1b[20~", "\x1b[21~", "\x1b[23~", "\x1b[24~", "\x1b[2~", "\x1b[3~", "\x1b[7~", "\x1b[8~", "\x1b[5~", "\x1b[6~", "\x1b[A", "\x1b[B", "\x1b[D", "\x1b[C", "\x1b[25~", "\x1b[26~", "\x1b[28~", "\x1b[29~", "\x1b[31~", "\x1b[32~", "\x1b[33~", "\x1b[34~", "", "", "", "", "\x1b[Z", "\x1bOM", "\x1bOw", "\x1bOy", "\x1bOu", "\x1bOq", "\x1bOs", "", "", "", "", "\x1b[1~", "\x1b[4~",
 }
 var rxvt_unicode_funcs = []string{
 	t_enter_ca:     "\x1b[?1049h",
@@ -94,13 +100,15 @@ var rxvt_unicode_funcs = []string{
 	t_reverse:      "\x1b[7m",
 	t_enter_keypad: "\x1b=",
 	t_exit_keypad:  "\x1b>",
+	t_enter_title:  "",
+	t_exit_title:   "",
 	t_enter_mouse:  ti_mouse_enter,
 	t_exit_mouse:   ti_mouse_leave,
 }
 
 // linux
 var linux_keys = []string{
-	"\x1b[[A", "\x1b[[B", "\x1b[[C", "\x1b[[D", "\x1b[[E", "\x1b[17~", "\x1b[18~", "\x1b[19~", "\x1b[20~", "\x1b[21~", "\x1b[23~", "\x1b[24~", "\x1b[2~", "\x1b[3~", "\x1b[1~", "\x1b[4~", "\x1b[5~", "\x1b[6~", "\x1b[A", "\x1b[B", "\x1b[D", "\x1b[C",
+	"\x1b[[A", "\x1b[[B", "\x1b[[C", "\x1b[[D", "\x1b[[E", "\x1b[17~", "\x1b[18~", "\x1b[19~", "\x1b[20~", "\x1b[21~", "\x1b[23~", "\x1b[24~", "\x1b[2~", "\x1b[3~", "\x1b[1~", "\x1b[4~", "\x1b[5~", "\x1b[6~", "\x1b[A", "\x1b[B", "\x1b[D", "\x1b[C", "\x1b[25~", "\x1b[26~", "\x1b[28~", "\x1b[29~", "\x1b[31~", "\x1b[32~", "\x1b[33~", "\x1b[34~", "", "", "", "", "\x1b\t", "", "", "", "\x1b[G", "", "", "", "", "", "", "", "",
 }
 var linux_funcs = []string{
 	t_enter_ca:     "",
@@ -118,13 +126,15 @@ var linux_funcs = []string{
 	t_reverse:      "\x1b[7m",
 	t_enter_keypad: "",
 	t_exit_keypad:  "",
+	t_enter_title:  "",
+	t_exit_title:   "",
 	t_enter_mouse:  "",
 	t_exit_mouse:   "",
 }
 
 // rxvt-256color
 var rxvt_256color_keys = []string{
-	"\x1b[11~", "\x1b[12~", "\x1b[13~", "\x1b[14~", "\x1b[15~", "\x1b[17~", "\x1b[18~", "\x1b[19~", "\x1b[20~", "\x1b[21~", "\x1b[23~", "\x1b[24~", "\x1b[2~", "\x1b[3~", "\x1b[7~", "\x1b[8~", "\x1b[5~", "\x1b[6~", "\x1b[A", "\x1b[B", "\x1b[D", "\x1b[C",
+	"\x1b[11~", "\x1b[12~", "\x1b[13~", "\x1b[14~", "\x1b[15~", "\x1b[17~", "\x1b[18~", "\x1b[19~", "\x1b[20~", "\x1b[21~", "\x1b[23~", "\x1b[24~", "\x1b[2~", "\x1b[3~", "\x1b[7~", "\x1b[8~", "\x1b[5~", "\x1b[6~", "\x1b[A", "\x1b[B", "\x1b[D", "\x1b[C", "\x1b[25~", "\x1b[26~", "\x1b[28~", "\x1b[29~", "\x1b[31~", "\x1b[32~", "\x1b[33~", "\x1b[34~", "\x1b[23$", "\x1b[24$", "\x1b[11^", "\x1b[12^", "\x1b[Z", "\x1bOM", "\x1bOw", "\x1bOy", "\x1bOu", "\x1bOq", "\x1bOs", "", "", "", "", "\x1b[1~", "\x1b[4~",
 }
 var rxvt_256color_funcs = []string{
 	t_enter_ca:     "\x1b7\x1b[?47h",
@@ -142,6 +152,8 @@ var rxvt_256color_funcs = []string{
 	t_reverse:      "\x1b[7m",
 	t_enter_keypad: "\x1b=",
 	t_exit_keypad:  "\x1b>",
+	t_enter_title:  "",
+	t_exit_title:   "",
 	t_enter_mouse:  ti_mouse_enter,
 	t_exit_mouse:   ti_mouse_leave,
 }
@@ -10,6 +10,9 @@ func TestTerminfoTerms(t *testing.T) {
 			if len(term.funcs) != t_max_funcs {
 				t.Errorf("want %d got %d terminfo entries", t_max_funcs, len(term.funcs))
 			}
+			if len(term.keys) != len(ti_keys) {
+				t.Errorf("want %d got %d terminfo keys", len(ti_keys), len(term.keys))
+			}
 		})
 	}
 }
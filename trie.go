@@ -0,0 +1,264 @@
+// +build !windows
+
+package termbox
+
+import (
+	"strings"
+	"sync"
+)
+
+// Result of matching the input against the escape sequences.
+type match_result int
+
+const (
+	match_invalid  match_result = iota // not an escape sequence
+	match_partial                      // the beginning of one, more input is needed
+	match_sequence                     // a complete sequence, but not a known key
+	match_key                          // a complete sequence of a known key
+)
+
+// States of the escape sequence grammar, see next_seq_state.
+type seq_state int
+
+const (
+	seq_start seq_state = iota
+	seq_esc
+	seq_csi_first // right after CSI, where X10 mouse reports may start
+	seq_csi
+	seq_csi_multi   // CSI with more than one param
+	seq_csi_private // CSI with a private marker, like CSI ? or CSI <
+	seq_csi_inter
+	seq_x10_1
+	seq_x10_2
+	seq_x10_3
+	seq_ss3
+	seq_string
+	seq_string_esc
+	seq_done
+	seq_invalid
+)
+
+// Prefix trie of the key sequences. The nodes live in a single slice and each
+// one refers to its first child and its next sibling by index, so that
+// matching doesn't allocate. The root is the node 0, which is never a child,
+// so 0 also means "no node".
+type seq_trie struct {
+	nodes []trie_node
+}
+
+type trie_node struct {
+	b       byte
+	leaf    bool
+	key     Key
+	mod     Modifier
+	child   int32
+	sibling int32
+}
+
+var (
+	key_trie seq_trie
+
+	// guards key_trie and user_keys, RegisterKey may change them while
+	// PollEvent reads the input
+	key_trie_mu sync.Mutex
+)
+
+// (Re)builds the trie from the terminfo keys and the modified key sequences,
+// which come first on conflicts. See also rebuild_key_trie.
+func (t *seq_trie) build(keys []string, mks []mod_key) {
+	t.nodes = append(t.nodes[:0], trie_node{})
+	for _, mk := range mks {
+		t.insert(mk.seq, mk.key, mk.mod)
+	}
+	for i, key := range keys {
+		// terminfo describes some keys as modified forms of others, e.g.
+		// xterm's kf13 is Shift+F1 (CSI 1;2P), those are left to
+		// parse_modified_key
+		if key == "" || strings.IndexByte(key, ';') >= 0 {
+			continue
+		}
+		t.insert(key, ti_key(i), 0)
+	}
+}
+
+// Rebuilds key_trie from the current keys, the keys registered with
+// RegisterKey taking precedence. Must be called with key_trie_mu held.
+func rebuild_key_trie() {
+	mks := make([]mod_key, 0, len(user_keys)+len(mod_keys))
+	mks = append(mks, user_keys...)
+	key_trie.build(keys, append(mks, mod_keys...))
+}
+
+// Matches the input against key_trie, see match.
+func match_key_seq(buf []byte) (int, Key, Modifier, match_result) {
+	key_trie_mu.Lock()
+	defer key_trie_mu.Unlock()
+	return key_trie.match(buf)
+}
+
+// Matches the input against the keys registered with RegisterKey, which may
+// start with anything, not only Esc.
+func match_user_key(buf []byte) (int, Key, Modifier, bool) {
+	key_trie_mu.Lock()
+	defer key_trie_mu.Unlock()
+	if len(user_keys) == 0 {
+		return 0, 0, 0, false
+	}
+	n, key, mod, res := key_trie.match(buf)
+	return n, key, mod, res == match_key
+}
+
+func (t *seq_trie) insert(seq string, key Key, mod Modifier) {
+	var n int32
+	for i := 0; i < len(seq); i++ {
+		c := t.child(n, seq[i])
+		if c == 0 {
+			c = int32(len(t.nodes))
+			t.nodes = append(t.nodes, trie_node{b: seq[i], sibling: t.nodes[n].child})
+			t.nodes[n].child = c
+		}
+		n = c
+	}
+	// the first sequence wins, like it used to with the linear search
+	if n != 0 && !t.nodes[n].leaf {
+		t.nodes[n].leaf = true
+		t.nodes[n].key = key
+		t.nodes[n].mod = mod
+	}
+}
+
+func (t *seq_trie) child(n int32, b byte) int32 {
+	for c := t.nodes[n].child; c != 0; c = t.nodes[c].sibling {
+		if t.nodes[c].b == b {
+			return c
+		}
+	}
+	return 0
+}
+
+// Matches the beginning of buf against the known keys and, at the same time,
+// against the grammar of escape sequences, in a single pass. Only registered
+// keys may start with something other than Esc. Returns the
+// length of the match, and the key if it is a known one. Known keys win over
+// sequences the grammar accepts, and a proper prefix of a known key is a
+// partial match even if the grammar considers it complete.
+func (t *seq_trie) match(buf []byte) (int, Key, Modifier, match_result) {
+	if len(buf) == 0 {
+		return 0, 0, 0, match_invalid
+	}
+	alive := len(t.nodes) != 0
+	var node int32
+	var leaf, seq_len int
+	var key Key
+	var mod Modifier
+	state := seq_start
+	i := 0
+	for ; i < len(buf); i++ {
+		b := buf[i]
+		if alive {
+			node = t.child(node, b)
+			alive = node != 0
+			if alive && t.nodes[node].leaf {
+				leaf = i + 1
+				key, mod = t.nodes[node].key, t.nodes[node].mod
+			}
+		}
+		if state != seq_done && state != seq_invalid {
+			state = next_seq_state(state, b)
+			if state == seq_done {
+				seq_len = i + 1
+			}
+		}
+		if !alive && (state == seq_done || state == seq_invalid) {
+			break
+		}
+	}
+
+	switch {
+	case leaf != 0:
+		return leaf, key, mod, match_key
+	case alive && t.nodes[node].child != 0:
+		return 0, 0, 0, match_partial
+	case state == seq_done:
+		return seq_len, 0, 0, match_sequence
+	case state != seq_invalid && i == len(buf):
+		return 0, 0, 0, match_partial
+	}
+	return 0, 0, 0, match_invalid
+}
+
+// The escape sequence grammar: CSI params intermediates final, SS3 final
+// (possibly with xterm modifier params), X10 mouse reports (CSI M and three
+// bytes), and the string sequences OSC, DCS, SOS, PM and APC, which end with
+// BEL or ST. rxvt's CSI n $ (Shift+key) ends with the '$', which is otherwise
+// an intermediate byte, as in CSI n ; m $ y.
+func next_seq_state(state seq_state, b byte) seq_state {
+	switch state {
+	case seq_start:
+		if b == '\033' {
+			return seq_esc
+		}
+	case seq_esc:
+		switch b {
+		case '[':
+			return seq_csi_first
+		case 'O':
+			return seq_ss3
+		case ']', 'P', 'X', '^', '_':
+			return seq_string
+		}
+	case seq_csi_first, seq_csi, seq_csi_multi, seq_csi_private:
+		switch {
+		case state == seq_csi_first && b == 'M':
+			return seq_x10_1
+		case state == seq_csi_first && b >= '<' && b <= '?':
+			return seq_csi_private
+		case b == ';' && state != seq_csi_private:
+			return seq_csi_multi
+		case b >= '0' && b <= '?':
+			if state == seq_csi_first {
+				return seq_csi
+			}
+			return state
+		case b == '$' && state == seq_csi:
+			return seq_done
+		case b >= ' ' && b <= '/':
+			return seq_csi_inter
+		case b >= '@' && b <= '~':
+			return seq_done
+		}
+	case seq_csi_inter:
+		switch {
+		case b >= ' ' && b <= '/':
+			return seq_csi_inter
+		case b >= '@' && b <= '~':
+			return seq_done
+		}
+	case seq_x10_1:
+		return seq_x10_2
+	case seq_x10_2:
+		return seq_x10_3
+	case seq_x10_3:
+		return seq_done
+	case seq_ss3:
+		switch {
+		case b >= '0' && b <= '9' || b == ';':
+			return seq_ss3
+		case b >= '@' && b <= '~':
+			return seq_done
+		}
+	case seq_string:
+		switch b {
+		case '\a':
+			return seq_done
+		case '\033':
+			return seq_string_esc
+		}
+		return seq_string
+	case seq_string_esc:
+		if b == '\\' {
+			return seq_done
+		}
+	}
+	return seq_invalid
+}
@@ -0,0 +1,47 @@
+// +build go1.18,!windows
+
+package termbox
+
+import "testing"
+
+func FuzzSeqTrieMatch(f *testing.F) {
+	for _, seed := range []string{
+		"\033[A", "\033[1;5A", "\033OP", "\033[<0;1;2M", "\033[M !!",
+		"\033]52;c;aGVsbG8=\a", "\033[200~", "\033[97;5u", "\033\033", "\033",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	var tr seq_trie
+	tr.build(xterm_keys, ti_mod_keys(map[string]string{"kUP5": "\033[1;5A"}))
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		n, _, _, res := tr.match(buf)
+		switch res {
+		case match_key, match_sequence:
+			if n < 2 || n > len(buf) {
+				t.Fatalf("%q: bad length %d", buf, n)
+			}
+			// a complete match stays the same with more input
+			more := append(append([]byte(nil), buf...), "\033[A"...)
+			if n2, _, _, res2 := tr.match(more); n2 != n || res2 != res {
+				t.Fatalf("%q: %d %d, with more input %d %d", buf, n, res, n2, res2)
+			}
+		case match_partial:
+			if n != 0 || len(buf) == 0 || buf[0] != '\033' {
+				t.Fatalf("%q: bad partial match", buf)
+			}
+		case match_invalid:
+			if n != 0 {
+				t.Fatalf("%q: bad invalid match", buf)
+			}
+			// an invalid prefix can't become valid
+			if len(buf) == 0 {
+				return
+			}
+			more := append(append([]byte(nil), buf...), "\033[A"...)
+			if _, _, _, res2 := tr.match(more); res2 != match_invalid {
+				t.Fatalf("%q: invalid, then %d with more input", buf, res2)
+			}
+		}
+	})
+}
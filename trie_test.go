@@ -0,0 +1,66 @@
+// +build !windows
+
+package termbox
+
+import "testing"
+
+func TestSeqTrieMatch(t *testing.T) {
+	var tr seq_trie
+	tr.build(linux_keys, []mod_key{{"\033[1;5A", KeyArrowUp, ModCtrl}})
+
+	tests := []struct {
+		in  string
+		n   int
+		key Key
+		mod Modifier
+		res match_result
+	}{
+		{"\033[A", 3, KeyArrowUp, 0, match_key},
+		{"\033[1;5Ax", 6, KeyArrowUp, ModCtrl, match_key},
+		{"\033[[Ax", 4, KeyF1, 0, match_key},
+		{"\033\t", 2, KeyBacktab, 0, match_key},
+		{"\033[[", 0, 0, 0, match_partial},
+		{"\033[1;", 0, 0, 0, match_partial},
+		{"\033", 0, 0, 0, match_partial},
+		{"\033[<0;1;2", 0, 0, 0, match_partial},
+		{"\033]52;c;aGVs", 0, 0, 0, match_partial},
+		{"\033[<0;1;2Mx", 9, 0, 0, match_sequence},
+		{"\033[M !!x", 6, 0, 0, match_sequence},
+		{"\033[2$x", 4, 0, 0, match_sequence},
+		{"\033[?2004;1$yx", 11, 0, 0, match_sequence},
+		{"\033O5Px", 4, 0, 0, match_sequence},
+		{"\033]11;rgb:0/0/0\033\\x", 16, 0, 0, match_sequence},
+		{"\033Pxyz\ax", 6, 0, 0, match_sequence},
+		{"\033a", 0, 0, 0, match_invalid},
+		{"\033\033[A", 0, 0, 0, match_invalid},
+		{"\033[1\x01", 0, 0, 0, match_invalid},
+		{"a", 0, 0, 0, match_invalid},
+	}
+	for _, tt := range tests {
+		n, key, mod, res := tr.match([]byte(tt.in))
+		if n != tt.n || key != tt.key || mod != tt.mod || res != tt.res {
+			t.Errorf("%q: want %d %d %d %d, got %d %d %d %d", tt.in,
+				tt.n, tt.key, tt.mod, tt.res, n, key, mod, res)
+		}
+	}
+
+	buf := []byte("\033[<35;120;40M")
+	if allocs := testing.AllocsPerRun(100, func() { tr.match(buf) }); allocs != 0 {
+		t.Errorf("match allocates %v times", allocs)
+	}
+}
+
+func benchmark_match(b *testing.B, in string) {
+	var tr seq_trie
+	tr.build(xterm_keys, nil)
+	buf := []byte(in)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tr.match(buf)
+	}
+}
+
+func BenchmarkMatchKey(b *testing.B)     { benchmark_match(b, "\033OA") }
+func BenchmarkMatchMouse(b *testing.B)   { benchmark_match(b, "\033[<35;120;40M") }
+func BenchmarkMatchPartial(b *testing.B) { benchmark_match(b, "\033[<35;12") }
+func BenchmarkMatchInvalid(b *testing.B) { benchmark_match(b, "\033x") }